@@ -0,0 +1,677 @@
+package compiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+var argreg = []string{
+	"rdi",
+	"rsi",
+	"rdx",
+	"rcx",
+	"r8",
+	"r9",
+}
+
+// The argument registers by the size of their part used for parameters
+// of that size.
+var argreg8 = []string{"dil", "sil", "dl", "cl", "r8b", "r9b"}
+var argreg16 = []string{"di", "si", "dx", "cx", "r8w", "r9w"}
+var argreg32 = []string{"edi", "esi", "edx", "ecx", "r8d", "r9d"}
+
+type codegen struct {
+	w        *bufio.Writer
+	labelseq int
+	funcname string
+}
+
+func (g *codegen) printf(format string, a ...interface{}) {
+	fmt.Fprintf(g.w, format, a...)
+}
+
+func (v *VarNode) GenAddr(g *codegen) {
+	if v.variable.isLocal {
+		g.printf("  lea rax, [rbp-%d]\n", v.variable.offset)
+		g.printf("  push rax\n")
+	} else {
+		g.printf("  push offset %s\n", v.variable.name)
+	}
+}
+
+func (m *Member) GenAddr(g *codegen) {
+	m.expr.(AddressGenerator).GenAddr(g)
+	g.printf("  pop rax\n")
+	g.printf("  add rax, %d\n", m.offset)
+	g.printf("  push rax\n")
+}
+
+func (n *Dereference) GenAddr(g *codegen) {
+	n.expr.Gen(g)
+}
+
+// load replaces the address on the stack by the value of type ty stored
+// there, sign-extended to 64 bits, or zero-extended if ty is unsigned.
+func (g *codegen) load(ty Type) {
+	g.printf("  pop rax\n")
+	unsigned := isUnsigned(ty)
+	switch {
+	case ty.size() == 1 && unsigned:
+		g.printf("  movzx rax, byte ptr [rax]\n")
+	case ty.size() == 1:
+		g.printf("  movsx rax, byte ptr [rax]\n")
+	case ty.size() == 2 && unsigned:
+		g.printf("  movzx rax, word ptr [rax]\n")
+	case ty.size() == 2:
+		g.printf("  movsx rax, word ptr [rax]\n")
+	case ty.size() == 4 && unsigned:
+		// Writing eax clears the upper half of rax.
+		g.printf("  mov eax, dword ptr [rax]\n")
+	case ty.size() == 4:
+		g.printf("  movsxd rax, dword ptr [rax]\n")
+	default:
+		g.printf("  mov rax, [rax]\n")
+	}
+	g.printf("  push rax\n")
+}
+
+// operandType returns the type that the operands of b are converted to
+// before the operation.
+func (b *Binary) operandType() Type {
+	return commonType(b.lhs.Type(), b.rhs.Type())
+}
+
+// regs returns the parts of rax and rdi that hold values of type ty
+// after the integer promotions.
+func regs(ty Type) (string, string) {
+	if is64(ty) {
+		return "rax", "rdi"
+	}
+	return "eax", "edi"
+}
+
+// extend sign-extends the 32-bit result in eax to rax if ty is a signed
+// type of 32 bits. Writing eax already zero-extends unsigned results.
+// The results of 64-bit operations are left as they are.
+func (g *codegen) extend(ty Type) {
+	if !is64(ty) && !isUnsigned(ty) {
+		g.printf("  movsxd rax, eax\n")
+	}
+}
+
+// store pops a value and an address and stores the value there as type
+// ty, leaving the value on the stack.
+func (g *codegen) store(ty Type) {
+	g.printf("  pop rdi\n")
+	g.printf("  pop rax\n")
+	switch ty.size() {
+	case 1:
+		g.printf("  mov [rax], dil\n")
+	case 2:
+		g.printf("  mov [rax], di\n")
+	case 4:
+		g.printf("  mov [rax], edi\n")
+	default:
+		g.printf("  mov [rax], rdi\n")
+	}
+	g.printf("  push rdi\n")
+}
+
+func (n *Number) Gen(g *codegen) {
+	// push takes a sign-extended 32-bit immediate.
+	if n.val == int(int32(n.val)) {
+		g.printf("  push %d\n", n.val)
+		return
+	}
+	g.printf("  movabs rax, %d\n", n.val)
+	g.printf("  push rax\n")
+}
+
+func (e *ExpressionStatement) Gen(g *codegen) {
+	e.statement.Gen(g)
+	g.printf("  add rsp, 8\n")
+}
+
+func (v *VarNode) Gen(g *codegen) {
+	v.GenAddr(g)
+	if _, ok := v.variable.ty.(*ArrayType); !ok {
+		g.load(v.variable.ty)
+	}
+}
+
+func (m *Member) Gen(g *codegen) {
+	m.GenAddr(g)
+	if _, ok := m.ty.(*ArrayType); !ok {
+		g.load(m.ty)
+	}
+}
+
+func (f *FuncRef) Gen(g *codegen) {
+	f.GenAddr(g)
+}
+
+func (f *FuncRef) GenAddr(g *codegen) {
+	g.printf("  push offset %s\n", f.name)
+}
+
+func (s *Sizeof) Gen(g *codegen) {
+	g.printf("  push %d\n", s.v.Type().size())
+}
+
+func (a *Assign) Gen(g *codegen) {
+	a.lhs.GenAddr(g)
+	if isRecord(a.ty) {
+		a.rhs.(AddressGenerator).GenAddr(g)
+		g.printf("  pop rdi\n")
+		g.printf("  pop rax\n")
+		for i := 0; i < a.ty.size(); i++ {
+			g.printf("  mov r8b, [rdi+%d]\n", i)
+			g.printf("  mov [rax+%d], r8b\n", i)
+		}
+		g.printf("  push rax\n")
+		return
+	}
+	a.rhs.Gen(g)
+	g.store(a.ty)
+}
+
+// truncate truncates the value in rax to the size of the integer type
+// ty and extends it back to 64 bits, with zeros if ty is unsigned. A
+// _Bool is 1 for any value but 0 instead. Other types are left as they
+// are.
+func (g *codegen) truncate(ty Type) {
+	if !isInteger(ty) {
+		return
+	}
+	unsigned := isUnsigned(ty)
+	switch {
+	case ty == boolType:
+		g.printf("  cmp rax, 0\n")
+		g.printf("  setne al\n")
+		g.printf("  movzx rax, al\n")
+	case ty.size() == 1 && unsigned:
+		g.printf("  movzx rax, al\n")
+	case ty.size() == 1:
+		g.printf("  movsx rax, al\n")
+	case ty.size() == 2 && unsigned:
+		g.printf("  movzx rax, ax\n")
+	case ty.size() == 2:
+		g.printf("  movsx rax, ax\n")
+	case ty.size() == 4 && unsigned:
+		g.printf("  mov eax, eax\n")
+	case ty.size() == 4:
+		g.printf("  movsxd rax, eax\n")
+	}
+}
+
+func (c *Cast) Gen(g *codegen) {
+	c.expr.Gen(g)
+	g.printf("  pop rax\n")
+	g.truncate(c.ty)
+	g.printf("  push rax\n")
+}
+
+func (a *Address) Gen(g *codegen) {
+	a.expr.GenAddr(g)
+}
+
+func (d *Dereference) Gen(g *codegen) {
+	d.expr.Gen(g)
+	switch d.ty.(type) {
+	case *ArrayType, *FuncType:
+	default:
+		g.load(d.ty)
+	}
+}
+
+func (i *If) Gen(g *codegen) {
+	g.labelseq++
+	seq := g.labelseq
+	if i.els != nil {
+		i.cond.Gen(g)
+		g.printf("  pop rax\n")
+		g.printf("  cmp rax, 0\n")
+		g.printf("  je .L.else.%d\n", seq)
+		i.then.Gen(g)
+		g.printf("  jmp .L.end.%d\n", seq)
+		g.printf(".L.else.%d:\n", seq)
+		i.els.Gen(g)
+		g.printf(".L.end.%d:\n", seq)
+	} else {
+		i.cond.Gen(g)
+		g.printf("  pop rax\n")
+		g.printf("  cmp rax, 0\n")
+		g.printf("  je .L.end.%d\n", seq)
+		i.then.Gen(g)
+		g.printf(".L.end.%d:\n", seq)
+	}
+}
+
+func (w *While) Gen(g *codegen) {
+	g.labelseq++
+	seq := g.labelseq
+	g.printf(".L.begin.%d:\n", seq)
+	w.cond.Gen(g)
+	g.printf("  pop rax\n")
+	g.printf("  cmp rax, 0\n")
+	g.printf("  je .L.end.%d\n", seq)
+	w.then.Gen(g)
+	g.printf("  jmp .L.begin.%d\n", seq)
+	g.printf(".L.end.%d:\n", seq)
+}
+
+func (f *For) Gen(g *codegen) {
+	g.labelseq++
+	seq := g.labelseq
+	if f.init != nil {
+		f.init.Gen(g)
+	}
+	g.printf(".L.begin.%d:\n", seq)
+	if f.cond != nil {
+		f.cond.Gen(g)
+		g.printf("  pop rax\n")
+		g.printf("  cmp rax, 0\n")
+		g.printf("  je .L.end.%d\n", seq)
+	}
+	f.block.Gen(g)
+	if f.inc != nil {
+		f.inc.Gen(g)
+	}
+	g.printf("  jmp .L.begin.%d\n", seq)
+	g.printf(".L.end.%d:\n", seq)
+}
+
+func (b *Block) Gen(g *codegen) {
+	for _, n := range b.body {
+		n.Gen(g)
+	}
+}
+
+func (f *FuncCall) Gen(g *codegen) {
+	// The address of the function to call goes below the arguments.
+	callee := f.name
+	if f.fn != nil {
+		f.fn.Gen(g)
+		callee = "r10"
+	}
+	nargs := 0
+	for _, arg := range f.args {
+		arg.Gen(g)
+		nargs++
+	}
+	for i := nargs - 1; i >= 0; i-- {
+		g.printf("  pop %s\n", argreg[i])
+	}
+	if f.fn != nil {
+		g.printf("  pop r10\n")
+	}
+
+	g.labelseq++
+	seq := g.labelseq
+	g.printf("  mov rax, rsp\n")
+	g.printf("  and rax, 15\n")
+	g.printf("  jnz .L.call.%d\n", seq)
+	g.printf("  mov rax, 0\n")
+	g.printf("  call %s\n", callee)
+	g.printf("  jmp .L.end.%d\n", seq)
+	g.printf(".L.call.%d:\n", seq)
+	g.printf("  sub rsp, 8\n")
+	g.printf("  mov rax, 0\n")
+	g.printf("  call %s\n", callee)
+	g.printf("  add rsp, 8\n")
+	g.printf(".L.end.%d:\n", seq)
+	// The callee only sets the part of rax that holds the return type,
+	// and a _Bool in al is already 0 or 1.
+	if f.ty == boolType {
+		g.truncate(ucharType)
+	} else {
+		g.truncate(f.ty)
+	}
+	g.printf("  push rax\n")
+}
+
+// The va_list state is the offsets of the next register arguments in
+// the register save area, then the addresses of the next argument on
+// the stack and of the save area.
+func (v *VaStart) Gen(g *codegen) {
+	v.ap.Gen(g)
+	g.printf("  pop rax\n")
+	g.printf("  mov dword ptr [rax], %d\n", len(v.fn.params)*8)
+	g.printf("  mov dword ptr [rax+4], 48\n")
+	// The stack arguments are above the saved rbp and return address,
+	// and a realigned frame keeps the old rsp at rbp.
+	if v.fn.frameAlign > 0 {
+		g.printf("  mov rdx, [rbp]\n")
+		g.printf("  lea rdx, [rdx+16]\n")
+	} else {
+		g.printf("  lea rdx, [rbp+16]\n")
+	}
+	g.printf("  mov [rax+8], rdx\n")
+	g.printf("  lea rdx, [rbp-%d]\n", v.fn.vaArea.offset)
+	g.printf("  mov [rax+16], rdx\n")
+	g.printf("  push rax\n")
+}
+
+func (v *VaArg) Gen(g *codegen) {
+	v.ap.Gen(g)
+	g.labelseq++
+	seq := g.labelseq
+	g.printf("  pop rcx\n")
+	g.printf("  mov eax, dword ptr [rcx]\n")
+	g.printf("  cmp eax, 48\n")
+	g.printf("  jae .L.va_stack.%d\n", seq)
+	g.printf("  add dword ptr [rcx], 8\n")
+	g.printf("  add rax, [rcx+16]\n")
+	g.printf("  jmp .L.end.%d\n", seq)
+	g.printf(".L.va_stack.%d:\n", seq)
+	g.printf("  mov rax, [rcx+8]\n")
+	g.printf("  add qword ptr [rcx+8], 8\n")
+	g.printf(".L.end.%d:\n", seq)
+	g.printf("  push rax\n")
+	g.load(v.ty)
+}
+
+func (v *VaCopy) Gen(g *codegen) {
+	v.dst.Gen(g)
+	v.src.Gen(g)
+	g.printf("  pop rsi\n")
+	g.printf("  pop rdi\n")
+	for i := 0; i < 24; i += 8 {
+		g.printf("  mov rax, [rsi+%d]\n", i)
+		g.printf("  mov [rdi+%d], rax\n", i)
+	}
+	g.printf("  push rdi\n")
+}
+
+func (v *VaEnd) Gen(g *codegen) {
+	v.ap.Gen(g)
+}
+
+func (r *Return) Gen(g *codegen) {
+	if r.expr != nil {
+		r.expr.Gen(g)
+		g.printf("  pop rax\n")
+	}
+	g.printf("  jmp .L.return.%s\n", g.funcname)
+}
+
+func (b *Binary) Gen(g *codegen) {
+	b.Lhs().Gen(g)
+	b.Rhs().Gen(g)
+	g.printf("  pop rdi\n")
+	g.printf("  pop rax\n")
+}
+
+func (a *Add) Gen(g *codegen) {
+	a.Binary.Gen(g)
+	if base := pointerBase(a.ty); base != nil {
+		g.printf("  imul rdi, %d\n", base.size())
+	}
+	ax, di := regs(a.ty)
+	g.printf("  add %s, %s\n", ax, di)
+	g.extend(a.ty)
+	g.printf("  push rax\n")
+}
+
+func (s *Sub) Gen(g *codegen) {
+	s.Binary.Gen(g)
+	if base := pointerBase(s.ty); base != nil {
+		g.printf("  imul rdi, %d\n", base.size())
+	}
+	ax, di := regs(s.ty)
+	g.printf("  sub %s, %s\n", ax, di)
+	g.extend(s.ty)
+	// The difference of two pointers counts elements, not bytes.
+	if base := pointerBase(s.lhs.Type()); base != nil && pointerBase(s.rhs.Type()) != nil {
+		g.printf("  mov rdi, %d\n", base.size())
+		g.printf("  cqo\n")
+		g.printf("  idiv rdi\n")
+	}
+	g.printf("  push rax\n")
+}
+
+func (m *Mul) Gen(g *codegen) {
+	m.Binary.Gen(g)
+	ax, di := regs(m.ty)
+	g.printf("  imul %s, %s\n", ax, di)
+	g.extend(m.ty)
+	g.printf("  push rax\n")
+}
+
+func (d *Div) Gen(g *codegen) {
+	d.Binary.Gen(g)
+	ty := d.operandType()
+	_, di := regs(ty)
+	switch {
+	case isUnsigned(ty):
+		g.printf("  mov edx, 0\n")
+		g.printf("  div %s\n", di)
+	case is64(ty):
+		g.printf("  cqo\n")
+		g.printf("  idiv %s\n", di)
+	default:
+		g.printf("  cdq\n")
+		g.printf("  idiv %s\n", di)
+	}
+	g.extend(ty)
+	g.printf("  push rax\n")
+}
+
+func (s *Shl) Gen(g *codegen) {
+	s.Binary.Gen(g)
+	ax, _ := regs(s.ty)
+	g.printf("  mov rcx, rdi\n")
+	g.printf("  shl %s, cl\n", ax)
+	g.extend(s.ty)
+	g.printf("  push rax\n")
+}
+
+// Shr shifts in zeros for an unsigned left operand and copies of the sign
+// bit otherwise.
+func (s *Shr) Gen(g *codegen) {
+	s.Binary.Gen(g)
+	ax, _ := regs(s.ty)
+	g.printf("  mov rcx, rdi\n")
+	if isUnsigned(s.ty) {
+		g.printf("  shr %s, cl\n", ax)
+	} else {
+		g.printf("  sar %s, cl\n", ax)
+	}
+	g.extend(s.ty)
+	g.printf("  push rax\n")
+}
+
+func (l *LogAnd) Gen(g *codegen) {
+	g.labelseq++
+	seq := g.labelseq
+	l.lhs.Gen(g)
+	g.printf("  pop rax\n")
+	g.printf("  cmp rax, 0\n")
+	g.printf("  je .L.false.%d\n", seq)
+	l.rhs.Gen(g)
+	g.printf("  pop rax\n")
+	g.printf("  cmp rax, 0\n")
+	g.printf("  je .L.false.%d\n", seq)
+	g.printf("  push 1\n")
+	g.printf("  jmp .L.end.%d\n", seq)
+	g.printf(".L.false.%d:\n", seq)
+	g.printf("  push 0\n")
+	g.printf(".L.end.%d:\n", seq)
+}
+
+func (l *LogOr) Gen(g *codegen) {
+	g.labelseq++
+	seq := g.labelseq
+	l.lhs.Gen(g)
+	g.printf("  pop rax\n")
+	g.printf("  cmp rax, 0\n")
+	g.printf("  jne .L.true.%d\n", seq)
+	l.rhs.Gen(g)
+	g.printf("  pop rax\n")
+	g.printf("  cmp rax, 0\n")
+	g.printf("  jne .L.true.%d\n", seq)
+	g.printf("  push 0\n")
+	g.printf("  jmp .L.end.%d\n", seq)
+	g.printf(".L.true.%d:\n", seq)
+	g.printf("  push 1\n")
+	g.printf(".L.end.%d:\n", seq)
+}
+
+func (n *Not) Gen(g *codegen) {
+	n.expr.Gen(g)
+	g.printf("  pop rax\n")
+	g.printf("  cmp rax, 0\n")
+	g.printf("  sete al\n")
+	g.printf("  movzx rax, al\n")
+	g.printf("  push rax\n")
+}
+
+// compare compares the operands of b, popped into rax and rdi, and pushes
+// 1 if the condition holds. signed and unsigned are the suffixes of the
+// set instruction for signed and unsigned operands.
+func (g *codegen) compare(b *Binary, signed string, unsigned string) {
+	b.Gen(g)
+	ty := b.operandType()
+	ax, di := regs(ty)
+	g.printf("  cmp %s, %s\n", ax, di)
+	if isUnsigned(ty) {
+		g.printf("  set%s al\n", unsigned)
+	} else {
+		g.printf("  set%s al\n", signed)
+	}
+	g.printf("  movzb rax, al\n")
+	g.printf("  push rax\n")
+}
+
+func (e *Equal) Gen(g *codegen) {
+	g.compare(e.Binary, "e", "e")
+}
+
+func (n *NotEqual) Gen(g *codegen) {
+	g.compare(n.Binary, "ne", "ne")
+}
+
+func (l *LessThan) Gen(g *codegen) {
+	g.compare(l.Binary, "l", "b")
+}
+
+func (l *LessEqual) Gen(g *codegen) {
+	g.compare(l.Binary, "le", "be")
+}
+
+func (n *Null) Gen(g *codegen) {}
+
+type Program struct {
+	globals []*Variable
+	funcs   []*Function
+}
+
+func (p *Program) emitData(g *codegen) {
+	g.printf(".data\n")
+
+	for _, v := range p.globals {
+		if v.isExtern {
+			continue
+		}
+		if !v.isStatic {
+			g.printf(".global %s\n", v.name)
+		}
+		g.printf(".align %d\n", v.align)
+		g.printf("%s:\n", v.name)
+
+		if len(v.contents) == 0 {
+			g.printf("  .zero %d\n", v.ty.size())
+			continue
+		}
+
+		relocs := v.relocs
+		for i := 0; i < len(v.contents); {
+			if len(relocs) > 0 && relocs[0].offset == i {
+				g.printf("  .quad %s%+d\n", relocs[0].label, relocs[0].addend)
+				relocs = relocs[1:]
+				i += 8
+				continue
+			}
+			g.printf("  .byte %d\n", v.contents[i])
+			i++
+		}
+	}
+}
+
+func (p *Program) emitText(g *codegen) {
+	g.printf(".text\n")
+
+	for _, fn := range p.funcs {
+		if !fn.isStatic {
+			g.printf(".global %s\n", fn.name)
+		}
+		g.printf("%s:\n", fn.name)
+		g.funcname = fn.name
+
+		g.printf("  push rbp\n")
+		g.printf("  mov rbp, rsp\n")
+		if fn.frameAlign > 0 {
+			// Move rbp down to a multiple of the alignment, keeping the
+			// old rsp just above the locals to restore on return.
+			g.printf("  sub rsp, 8\n")
+			g.printf("  and rsp, %d\n", -fn.frameAlign)
+			g.printf("  mov [rsp], rbp\n")
+			g.printf("  mov rbp, rsp\n")
+		}
+		g.printf("  sub rsp, %d\n", fn.stackSize)
+
+		for i, v := range fn.params {
+			var reg string
+			switch v.ty.size() {
+			case 1:
+				reg = argreg8[i]
+			case 2:
+				reg = argreg16[i]
+			case 4:
+				reg = argreg32[i]
+			default:
+				reg = argreg[i]
+			}
+			g.printf("  mov [rbp-%d], %s\n", v.offset, reg)
+		}
+		if fn.vaArea != nil {
+			for i, reg := range argreg {
+				g.printf("  mov [rbp-%d], %s\n", fn.vaArea.offset-i*8, reg)
+			}
+			for i := 0; i < 8; i++ {
+				g.printf("  movaps [rbp-%d], xmm%d\n", fn.vaArea.offset-48-i*16, i)
+			}
+		}
+
+		for _, n := range fn.node {
+			n.Gen(g)
+		}
+
+		// Falling off the end of main returns 0.
+		if fn.name == "main" {
+			g.printf("  mov rax, 0\n")
+		}
+		g.printf(".L.return.%s:\n", g.funcname)
+		if fn.frameAlign > 0 {
+			g.printf("  mov rsp, [rbp]\n")
+		} else {
+			g.printf("  mov rsp, rbp\n")
+		}
+		g.printf("  pop rbp\n")
+		g.printf("  ret\n")
+	}
+}
+
+// Codegen writes the assembly for the program to w.
+func (p *Program) Codegen(w io.Writer) error {
+	g := &codegen{w: bufio.NewWriter(w)}
+	g.printf(".intel_syntax noprefix\n")
+
+	p.emitData(g)
+	p.emitText(g)
+
+	// Mark the stack as non-executable so the linker doesn't warn.
+	g.printf(".section .note.GNU-stack,\"\",@progbits\n")
+	return g.w.Flush()
+}
@@ -0,0 +1,113 @@
+// Package compiler implements a compiler from C to x86-64 assembly in
+// Intel syntax.
+package compiler
+
+import (
+	"bytes"
+	"io"
+)
+
+// Options configures a compilation.
+type Options struct {
+	// Filename is the name of the source file, used in error messages
+	// and to find files included with #include "...".
+	Filename string
+	// IncludePaths are the directories searched by #include, like -I,
+	// before the headers bundled with the compiler.
+	IncludePaths []string
+	// Included, if non-nil, is called with the path of each file read
+	// by #include.
+	Included func(path string)
+	// Macros are defined and undefined in order before preprocessing,
+	// like -D and -U.
+	Macros []MacroDef
+	// MaxErrors is the number of errors after which compilation stops.
+	// Zero means no limit.
+	MaxErrors int
+
+	// Warnings enables or disables warnings by name, such as
+	// WarnUnusedVariable. Warnings not in the map keep their default
+	// state; only WarnReturnType and WarnMultichar are enabled by
+	// default.
+	Warnings map[string]bool
+	// WarningsAsErrors reports enabled warnings as errors, like -Werror.
+	WarningsAsErrors bool
+
+	// Report, if non-nil, is called with every error and warning once
+	// compilation finishes, in order of position.
+	Report func(*Diagnostic)
+}
+
+// Compile compiles the C source src and returns the generated assembly.
+// If the source contains errors, the returned error is an ErrorList.
+// Warnings are only available through Options.Report.
+func Compile(src []byte, opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := CompileTo(&buf, src, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Preprocess preprocesses the C source src and writes the result to w
+// as text, with line markers giving the origin of the lines, like -E. If
+// the source contains errors, nothing is written and the returned error
+// is an ErrorList.
+func Preprocess(w io.Writer, src []byte, opts Options) (err error) {
+	diag := newDiagnostics(opts)
+	defer func() {
+		if e := diag.finish(recover(), opts.Report); e != nil {
+			err = e
+		}
+	}()
+
+	token := newPreprocessor(diag, opts).preprocessFile(&File{Name: opts.Filename, Contents: string(src)})
+	if diag.nerrors > 0 {
+		return nil
+	}
+	return printTokens(w, token)
+}
+
+// CompileTo compiles the C source src and writes the generated assembly
+// to w. If the source contains errors, nothing is written and the
+// returned error is an ErrorList.
+func CompileTo(w io.Writer, src []byte, opts Options) (err error) {
+	diag := newDiagnostics(opts)
+	defer func() {
+		if e := diag.finish(recover(), opts.Report); e != nil {
+			err = e
+		}
+	}()
+
+	token := newPreprocessor(diag, opts).preprocessFile(&File{Name: opts.Filename, Contents: string(src)})
+	convertTokens(token, diag)
+	joinAdjacentStrings(token)
+	parser := NewParser(token, diag)
+	prog := parser.Program()
+	for i := range prog.funcs {
+		prog.funcs[i].AddType(diag)
+	}
+	if diag.nerrors > 0 {
+		// The deferred function returns the errors.
+		return nil
+	}
+
+	// Each local lies below the previous one at an address, rbp minus
+	// its offset, that is a multiple of its alignment. rbp itself is
+	// aligned to 16 bytes, or realigned to the largest alignment above
+	// that, and the stack size is a multiple of 16.
+	for i := range prog.funcs {
+		offset := 0
+		for j := range prog.funcs[i].locals {
+			v := prog.funcs[i].locals[j]
+			offset = alignTo(offset+v.ty.size(), v.align)
+			v.offset = offset
+			if v.align > 16 && v.align > prog.funcs[i].frameAlign {
+				prog.funcs[i].frameAlign = v.align
+			}
+		}
+		prog.funcs[i].stackSize = alignTo(offset, 16)
+	}
+
+	return prog.Codegen(w)
+}
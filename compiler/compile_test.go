@@ -0,0 +1,881 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	type testData struct {
+		expected int
+		input    string
+	}
+	data := []testData{
+		{0, "int main() { return 0; }"},
+		{42, "int main() {return 42; }"},
+		{21, "int main() { return 5+20-4; }"},
+		{41, "int main() { return  12 + 34 - 5 ; }"},
+		{47, "int main() { return 5+6*7; }"},
+		{0, "int main() { return 0==1; }"},
+		{1, "int main() { return 0<1; }"},
+		{1, "int main() { return 1>0; }"},
+		{3, "int main() { int foo=3; return foo; }"},
+		{8, "int main() { int foo123=3; int bar=5; return foo123+bar; }"},
+
+		{3, "int main() { if (0) return 2; return 3; }"},
+		{3, "int main() { if (1-1) return 2; return 3; }"},
+		{2, "int main() { if (1) return 2; return 3; }"},
+		{2, "int main() { if (2-1) return 2; return 3; }"},
+		{4, "int main() { int x=0; if (x) return 3; else return 4; }"},
+		{5, "int main() { int x=2; if (x==1) return 3; else if (x==2) return 5; else return 4; }"},
+
+		{10, "int main () { int i=0; while(i<10) i=i+1; return i; }"},
+
+		{55, "int main() { int i=0; int j=0; for (i=0; i<=10; i=i+1) j=i+j; return j; }"},
+		{3, "int main() { for (;;) return 3; return 5; }"},
+
+		{32, "int main() { return ret32(); } int ret32() { return 32; }"},
+		{7, "int main() { return add2(3,4); } int add2(int x, int y) { return x+y; }"},
+		{55, "int main() { return fib(9); } int fib(int x) { if (x<=1) return 1; return fib(x-1) + fib(x-2); }"},
+
+		{8, "int main() { int x=3; int y=5; return foo(&x, y); } int foo(int *x, int y) { return *x + y; }"},
+
+		{3, "int main() { int x[2]; int *y=&x; *y=3; return *x; }"},
+		{1, "int main() { int x[2][3]; int *y=x; *(y+1)=1; return *(*x+1); }"},
+
+		{1, "int main() { int x[2][3]; int *y=x; y[1]=1; return x[0][1]; }"},
+
+		{4, "int main() { int x; return sizeof(x); }"},
+		{4, "int main() { int x; return sizeof x; }"},
+		{8, "int main() { int *x; return sizeof(x); }"},
+		{16, "int main() { int x[4]; return sizeof(x); }"},
+
+		{3, "int x; int main() { x=3; return x; }"},
+		{4, "int x; int main() { return sizeof(x); }"},
+
+		{1, "int main() { char x=1; return x; }"},
+		{1, "int main() { char x; return sizeof(x); }"},
+		{10, "int main() { char x[10]; return sizeof(x); }"},
+
+		{98, "int main() { return \"abc\"[1]; }"},
+		{9, "int main() { return \"\\t\"[0]; }"},
+		{2, "int main() { int x=2; { int x=3; } return x; }"},
+
+		{1, "int main() { struct {int a; int b;} x; x.a=1; x.b=2; return x.a; }"},
+		{0, "int main() { int x; x = 5; }"},
+
+		{3, "int main() { int integer=3; return integer; }"},
+		{7, "int main() { int iffy=3; int returned=4; return iffy+returned; }"},
+		{5, "int format() { return 5; } int main() { return format(); }"},
+		{2, "int main() <% int x<:2:>; x<:1:>=2; return x<:1:>; %>"},
+
+		{97, "int main() { return 'a'; }"},
+		{10, "int main() { return '\\n'; }"},
+		{39, "int main() { return '\\''; }"},
+		{65, "int main() { return '\\x41'; }"},
+		{65, "int main() { return '\\101'; }"},
+		{0, "int main() { return '\\0'; }"},
+		{1, "int main() { return '\\xff' == 0-1; }"},
+		{97, "int main() { return 'ab' / 256; }"},
+		{65, "int main() { return \"\\x41\"[0]; }"},
+		{7, "int main() { return \"\\a\\101\"[0]; }"},
+
+		{4, "int main() { return sizeof(\"abc\"); }"},
+		{0, "int main() { return \"abc\"[3]; }"},
+		{100, "int main() { return \"abc\" \"def\"[3]; }"},
+		{7, "int main() { return sizeof(\"abc\"\n  \"def\"); }"},
+		{0xc3, "int main() { return \"\\u00e9\"[0] + 256; }"},
+		{0xa9, "int main() { return \"\\U000000e9\"[1] + 256; }"},
+		{5, "int main() { return sizeof(\"\\u20ac\\x41\"); }"},
+		{0x24, "int main() { return '\\u0024'; }"},
+
+		{16, "int main() { return 0x10; }"},
+		{255, "int main() { return 0XfF; }"},
+		{493 % 256, "int main() { return 0755; }"},
+		{5, "int main() { return 0b101; }"},
+		{10, "int main() { return 10UL; }"},
+		{10, "int main() { return 10llu + 0u + 0LL; }"},
+		{1, "int main() { return 4294967296 / 4294967296; }"},
+		{1, "int main() { return 9223372036854775807 == 0x7fffffffffffffff; }"},
+		{1, "int main() { return 18446744073709551615u == 0-1; }"},
+		{3, "#define N 3\nint main() { return N; }"},
+		{6, "#define A B + B\n#define B 3\nint main() { return A; }"},
+		{2, "#define x x\nint main() { int x; x = 2; return x; }"},
+		{5, "#define N 5\n#undef N\nint main() { int N; N = 5; return N; }"},
+		{7, "#define T int\n  #  define RET \\\n  return\nT main() { RET 7; }"},
+		{0, "#\nint main() { return 0; }"},
+		{5, "#define MAX(a, b) (b - a < 0) * a + (a - b <= 0) * b\nint main() { return MAX(2, 5); }"},
+		{7, "#define ADD(...) add(__VA_ARGS__)\nint add(int a, int b) { return a + b; }\nint main() { return ADD(3, 4); }"},
+		{4, "#define STR(x) #x\nint main() { return sizeof(STR(a b)); }"},
+		{12, "#define CAT(a, b) a##b\nint main() { int xy; xy = 12; return CAT(x, y); }"},
+		{5, "int main() { return sizeof(__func__); }"},
+		{2, "int f() { return sizeof(__func__); }\nint main() {\n return f() + __LINE__ - 3; }"},
+		{42, "#line 40\n\n#define L __LINE__\nint main() { return L; }"},
+		{1, "#if __STDC__ && __STDC_VERSION__ == 201112L && __x86_64__ && __LP64__\nint main() { return 1; }\n#endif"},
+		{12, "int main() { return sizeof(__DATE__) + sizeof(__TIME__) - sizeof(__FILE__) - 8; }"},
+		{3, "int add(int a, int b);\nint main() { return add(1, 2); }\nint add(int a, int b) { return a + b; }"},
+		{8, "typedef int T;\ntypedef T *P;\nint main() { T x; P p; p = &x; *p = 8; return x; }"},
+		{2, "int main() { typedef char A[2]; A a; return sizeof(a); }"},
+		{5, "int main() { const char *const s = \"abcde\"; return sizeof(s) - 3; }"},
+		{12, "#include <stdio.h>\nint main() { return printf(\"hello, %s\\n\", \"world\") - 1; }"},
+		{0, "#include <stdio.h>\nint main() { printf(\"%d\\n\", 42); return fflush(stdout); }"},
+		{11, "#include <string.h>\n#include <stdlib.h>\nint main() { char *p = malloc(16); strcpy(p, \"hello\"); strcat(p, \" world\"); return strlen(p); }"},
+		{3, "__extension__ typedef int T __attribute__((aligned(8)));\nstatic __inline T add(T *__restrict a, const T b) { return *a + b; }\nint main() { T x = 1; return add(&x, 2); }"},
+		{5, "int five() { return 5; }\nint f(void) __asm__(\"five\");\nint main() { return f(); }"},
+		{7, "typedef int F(int, int);\nF add;\nint add(int a, int b) { return a + b; }\nint main() { F *fp; return add(3, 4) + sizeof(fp) - 8; }"},
+		{8, "int main() { int (*fp)(int), *(*ap)[3]; return sizeof(fp) + sizeof(*ap) - 24; }"},
+		{7, "int add(int a, int b) { return a + b; }\nint main() { int (*fp)(int, int); fp = add; return fp(3, 4); }"},
+		{12, "typedef int F(int);\nint twice(int x) { return x * 2; }\nint apply(F *f, int x) { return f(x); }\nint main() { F *fp = &twice; int (*fs[2])(int); fs[0] = twice; fs[1] = fp; return (*fp)(1) + fs[0](2) + apply(fs[1], 3); }"},
+		{5, "int x, *y;\nint main() { int a = 2, b, c = 3; return a + c; }"},
+		{8, "int main() { unsigned long long int x; signed short y; return sizeof(x); }"},
+		{24, "int main() { __builtin_va_list ap; return sizeof(ap); }"},
+		{2, "int main() { short x; return sizeof(x); }"},
+		{16, "int main() { long x; long long int y; return sizeof(x) + sizeof(y); }"},
+		{1, "int main() { int a[2]; a[0] = -1; a[1] = 2; return a[0] + a[1]; }"},
+		{1, "int main() { char c; c = 255; return c + 2; }"},
+		{1, "int main() { short s; s = 65535; return s + 2; }"},
+		{7, "int main() { int x; x = 4294967303; return x; }"},
+		{10, "int f(char a, short b, int c, long d) { return a + b + c + d; }\nint main() { return f(-1, -2, -3, 16); }"},
+		{4, "int main() { return sizeof(1) + sizeof(2147483647) - sizeof('a'); }"},
+		{24, "int main() { return sizeof(1L) + sizeof(2147483648) + sizeof(1ll); }"},
+		{24, "#include <stdarg.h>\nint main() { va_list ap; return sizeof(ap); }"},
+		{15, "#include <stdarg.h>\nint sum(int n, ...) { va_list ap; va_start(ap, n); int s = 0; for (; n > 0; n = n - 1) s = s + va_arg(ap, int); va_end(ap); return s; }\nint main() { return sum(5, 1, 2, 3, 4, 5); }"},
+		{121, "#include <stdarg.h>\nint next(va_list ap) { return va_arg(ap, int); }\nint f(int n, ...) { va_list ap, aq; va_start(ap, n); va_copy(aq, ap); int a = next(ap); int b = next(ap); int c = va_arg(aq, int); va_end(aq); va_end(ap); return a * 100 + b * 10 + c; }\nint main() { return f(0, 1, 2); }"},
+		{5, "#include <stdio.h>\n#include <string.h>\nchar buf[32];\nint fmt(char *f, ...) { va_list ap; va_start(ap, f); int n = vsprintf(buf, f, ap); va_end(ap); return n; }\nint main() { return fmt(\"%d-%s\", 42, \"ab\") * (strcmp(buf, \"42-ab\") == 0); }"},
+		{65, "#if L'\\0' - 1 < 0\nint main() { return L'A'; }\n#endif"},
+		{1, "#include <stdbool.h>\n#include <stddef.h>\n#include <limits.h>\n#include <stdint.h>\n#include <stdarg.h>\nint main() { bool b = true; int64_t x = INT64_MAX; int32_t y = INT32_MIN; return (b == 1) * (x == LONG_MAX) * (y == INT_MIN) * (sizeof(y) == 4) * (NULL == 0); }"},
+		{200, "int main() { unsigned char c; c = 200; return c; }"},
+		{255, "int main() { unsigned short s; s = 65535; return s / 257; }"},
+		{1, "int main() { unsigned x; x = 0; x = x - 1; return x / 2 == 2147483647; }"},
+		{1, "int main() { int x; x = -1; return x / 2 == 0; }"},
+		{1, "int main() { unsigned x; int y; x = 1; y = -1; return x < y; }"},
+		{0, "int main() { long x; unsigned y; x = -1; y = 1; return x > y; }"},
+		{1, "int main() { unsigned long x; x = 0; return x - 1 > 0; }"},
+		{1, "int main() { char *p; char *q; p = 0; q = p - 1; return p <= q; }"},
+		{20, "int main() { return (5 << 3) >> 1; }"},
+		{1, "int main() { int x; x = -16; return x >> 2 == -4; }"},
+		{1, "int main() { unsigned x; x = 0; x = x - 16; return x >> 28 == 15; }"},
+		{1, "int main() { return (1u << 31 >> 31) == 1; }"},
+		{8, "int main() { return sizeof(1u) + sizeof(0xffffffff) + sizeof(4294967296) - sizeof(1ul); }"},
+		{1, "#include <stdint.h>\n#include <limits.h>\nint main() { uint8_t a = UINT8_MAX; uint32_t b = UINT32_MAX; uint64_t c = UINT64_MAX; return (a == 255) * (b > INT_MAX) * (c == SIZE_MAX) * (sizeof(b) == 4); }"},
+		{4, "int main() { char c; c = 1; return sizeof(c + c); }"},
+		{8, "int main() { char c; long l; c = 1; l = 2; return sizeof(c + l); }"},
+		{4, "int main() { char c; return sizeof(c << 1) + sizeof(c == c) - sizeof(1 << 1L); }"},
+		{3, "int main() { int a[4]; a[2] = 3; return *(2 + a); }"},
+		{5, "int main() { int a[4]; a[1] = 5; return 1[a]; }"},
+		{3, "int main() { int a[4]; int *p; int *q; p = a; q = a + 3; return q - p; }"},
+		{8, "int main() { int a[4]; int *p; p = a; return sizeof(p - p) + sizeof(*(p + 1)) - 4; }"},
+		{1, "int main() { int x; char c; x = (c = 255); return x == -1; }"},
+		{1, "int main() { unsigned x; long y; x = 0; y = x - 1; return y == 4294967295; }"},
+		{1, "int main() { unsigned char a; unsigned char b; a = 200; b = 100; return a + b == 300; }"},
+		{1, "int main() { int x; x = 65536; return x * x == 0; }"},
+		{1, "int main() { short s; s = 200; return s * s / 4 == 10000; }"},
+		{3, "int x; void set(int v) { x = v; return; }\nint main() { set(3); return x; }"},
+		{5, "int x; void set(int v) { if (v < 0) return; x = v; }\nvoid f(int v) { return set(v); }\nint main() { f(5); f(-1); return x; }"},
+		{1, "char f(int x) { return x; }\nint main() { return f(257); }"},
+		{1, "unsigned char f();\nint main() { return f() == 255; }\nunsigned char f() { return 511; }"},
+		{1, "long f(long x) { return x; }\nint main() { return f(-1) == -1; }"},
+		{4, "int main() { int x; void *p; int *q; x = 4; p = &x; q = p; return *q; }"},
+		{3, "int main() { int a[2]; void *p; p = a; p = p + 3; return (char *)p - (char *)a; }"},
+		{1, "int main() { return (char)256 + (unsigned char)-1 == 255; }"},
+		{1, "int main() { return (long)-1 < (unsigned)0; }"},
+		{0, "void f() {}\nint main() { (void)1; f(); return 0; }"},
+		{6, "#include <stdlib.h>\n#include <string.h>\nint main() { char *p = malloc(8); int n; memset(p, 'x', 6); p[6] = 0; n = strlen(p); free(p); return n; }"},
+		{1, "#include <stddef.h>\nint main() { int *p = NULL; return p == NULL; }"},
+		{3, "#include <stdlib.h>\nint main() { exit(3); return 0; }"},
+		{1, "int main() { _Bool b; b = 256; return b; }"},
+		{1, "int main() { _Bool b; return sizeof(b); }"},
+		{3, "int main() { _Bool a; _Bool b; char *p; a = -1; p = &a; b = p; return a + b + (b == 1); }"},
+		{0, "int main() { _Bool b; long x; x = 4294967296; b = x; b = b - 1; return b; }"},
+		{1, "_Bool f(int x) { return x; }\nint main() { return f(512); }"},
+		{2, "int f(_Bool b) { return b + 1; }\nint main() { return f(2); }"},
+		{1, "#include <stdbool.h>\nint main() { bool b = 256; return b == true; }"},
+		{1, "int main() { return !0 + !5; }"},
+		{5, "int main() { int x; x = 0; if (1 && 2) x = x + 1; if (1 && 0) x = x + 10; if (0 || 3) x = x + 4; if (0 || 0) x = x + 20; return x; }"},
+		{0, "int x; int f() { x = 1; return 1; }\nint main() { 0 && f(); 1 || f(); return x; }"},
+		{4, "int main() { return sizeof(1 < 2) + sizeof(1 && 2) - sizeof(!1); }"},
+		{8, "int main() { struct { char c; int x; } s; return sizeof(s); }"},
+		{24, "int main() { struct { char c; long x; short y; } s; return sizeof(s); }"},
+		{6, "int main() { struct { char a; short b; char c; } s; return sizeof(s); }"},
+		{3, "int main() { struct { char c; int x; } s; s.c = 1; s.x = 2; return s.c + s.x; }"},
+		{1, "int main() { struct { char c; int x; } s; return (char *)&s.x - (char *)&s == 4; }"},
+		{16, "int main() { struct { char c; struct { char d; long e; } t; } s; return sizeof(s.t); }"},
+		{24, "int main() { struct { char c; struct { char d; long e; } t; } s; return sizeof(s); }"},
+		{12, "int main() { struct { int a[2]; char b; } s; return sizeof(s); }"},
+		{1, "int main() { char c; long x; return (long)&x / 8 * 8 == (long)&x; }"},
+		{1, "int main() { char c; _Alignas(16) char d; return (long)&d / 16 * 16 == (long)&d; }"},
+		{1, "char c; long x; _Alignas(32) char d;\nint main() { return ((long)&x / 8 * 8 == (long)&x) * ((long)&d / 32 * 32 == (long)&d); }"},
+		{6, "int f(int x) { char c; _Alignas(64) int d; _Alignas(32) char e; d = x; return ((long)&d / 64 * 64 == (long)&d) * ((long)&e / 32 * 32 == (long)&e) * d; }\nint g() { long x; return f(2); }\nint main() { return f(1) + g() + f(3); }"},
+		{16, "int main() { struct { char c; _Alignas(8) char d; } s; return sizeof(s) + (char *)&s.d - (char *)&s - 8; }"},
+		{8, "int main() { struct { char c; _Alignas(long) char d; } s; return _Alignof(long) * (sizeof(s) == 16); }"},
+		{15, "int main() { return _Alignof(char) + _Alignof(short) + _Alignof(int) + _Alignof(char *); }"},
+		{4, "int main() { return _Alignof(struct { char c; int x; }) + __alignof__(int[3]) - 4; }"},
+		{22, "int main() { return sizeof(int) + sizeof(char *) + sizeof(int[2]) + sizeof(short); }"},
+		{1, "typedef struct { char c; long l; } S;\nint main() { return sizeof(S) == 16 && _Alignof(S) == 8; }"},
+		{8, "struct P { int x; int y; };\nint main() { struct P p; p.x = 3; p.y = 5; return p.x + p.y; }"},
+		{12, "struct P { int x; int y; };\nint main() { struct P p; struct P *q; q = &p; q->x = 5; q->y = 7; return p.x + p.y; }"},
+		{6, "struct Node { int val; struct Node *next; };\nint main() { struct Node a; struct Node b; struct Node c; struct Node *n; int sum;\n a.val = 1; b.val = 2; c.val = 3; a.next = &b; b.next = &c; c.next = 0;\n sum = 0; for (n = &a; n; n = n->next) sum = sum + n->val; return sum; }"},
+		{12, "struct S { int a; long b; char c; };\nint main() { struct S x; struct S y; struct S *p; x.a = 1; x.b = 5; x.c = 6; p = &y; *p = x; x.b = 0; return y.a + y.b + y.c; }"},
+		{7, "struct T;\nstruct T *f(struct T *t) { return t; }\nstruct T { int v; };\nint main() { struct T t; t.v = 7; return f(&t)->v; }"},
+		{3, "typedef struct List List;\nstruct List { List *next; int v; };\nint main() { List a; List b; a.next = &b; b.v = 3; return a.next->v; }"},
+		{4, "struct S { int a; };\nint main() { struct S { char a; char b; char c; char d; } s; return sizeof(s); }"},
+		{4, "struct S { int a; };\nint main() { { struct S { char a; } s; } struct S t; return sizeof(t); }"},
+		{8, "struct A { struct B *b; };\nint main() { struct B; struct A a; return sizeof(a); }"},
+		{2, "int main() { struct S { struct S *l; struct S *r; int v; } x; struct S y; x.l = &y; y.v = 2; return x.l->v; }"},
+		{3, "int main() { struct { struct { int a; } in; } s; s.in.a = 3; return s.in.a; }"},
+		{1, "#include <stdio.h>\nint main() { FILE *f = stdout; return f != 0; }"},
+		{8, "int main() { union { char c; int x; long l; } u; return sizeof(u); }"},
+		{8, "int main() { union { char c[5]; int x; } u; return sizeof(u) + _Alignof(union { char c[5]; int x; }) - 4; }"},
+		{5, "int main() { union { char c[5]; char d; } u; return sizeof(u); }"},
+		{1, "int main() { union { int x; char c; } u; u.x = 257; return u.c; }"},
+		{5, "int x = 5;\nint main() { return x; }"},
+		{15, "union U { char c; int i; } u = {1};\nstruct S { char a; int b[2]; union U u; } s = {2, {3, 4}, {5}};\nint main() { return u.i + s.a + s.b[0] + s.b[1] + s.u.c + sizeof(s) - 16; }"},
+		{8, "int a[3] = {1, 2 * 3, -1};\nint *p = a + 1;\nchar *s = \"hi\";\nint (*fp)(void);\nint main() { return *p + s[1] - 'h' + a[2] + 2 + (fp == 0) - 1; }"},
+		{4, "int three() { return 3; }\nint (*fp)() = three;\nstruct { int *p; int (*f)(); } t = {0, &three};\nint main() { return fp() + t.f() + (t.p == 0) - 3; }"},
+		{1, "_Bool b = 2; unsigned char c = 257; short s = -1;\nint main() { return b * c * (s == -1); }"},
+		{1, "int main() { union { int x; char c; } u; return (char *)&u.c == (char *)&u.x; }"},
+		{7, "union U { int i; unsigned char b[4]; };\nint main() { union U u; union U *p; p = &u; p->i = 0; p->b[0] = 7; return u.i; }"},
+		{12, "struct Msg { int kind; union { int i; char c; } v; };\nint main() { struct Msg m; m.kind = 2; m.v.i = 10; return m.kind + m.v.i; }"},
+		{8, "union L;\nunion L *f(union L *l) { return l; }\nunion L { long v; union L *next; };\nint main() { union L l; return sizeof(*f(&l)); }"},
+		{7, "int main() { union { char c[12]; int i; } u, v; u.c[10] = 7; v = u; return v.c[10]; }"},
+		{6, "int main() { int a[3] = {1, 2, 3}; return a[0] + a[1] + a[2]; }"},
+		{2, "int main() { int a[3] = {2}; return a[0] + a[1] + a[2]; }"},
+		{0, "int main() { int a[2] = {}; return a[0] + a[1]; }"},
+		{5, "int main() { int x = {5}; return x; }"},
+		{7, "struct P { int x; int y; };\nint main() { struct P p = {3, 4,}; return p.x + p.y; }"},
+		{3, "struct P { int x; int y; };\nint main() { struct P p = {3}; return p.x + p.y; }"},
+		{10, "struct P { int x; int y; };\nint main() { struct P a[2] = {{1, 2}, {3, 4}}; return a[0].x + a[0].y + a[1].x + a[1].y; }"},
+		{1, "int main() { union { char c; int x; } u = {1}; return u.c; }"},
+		{9, "struct T { int tag; union { int i; char c; } v; };\nint main() { struct T t = {2, {7}}; return t.tag + t.v.i; }"},
+	}
+
+	exeFile := filepath.Join(t.TempDir(), "tmp")
+
+	for _, v := range data {
+		asm, err := Compile([]byte(v.input), Options{})
+		if err != nil {
+			t.Fatalf("%v: %v", v.input, err)
+		}
+
+		exitCode := run(t, asm, exeFile)
+		t.Logf("%v => %v (expected: %v)\n", v.input, exitCode, v.expected)
+		if exitCode != v.expected {
+			t.Errorf("Failed to run program")
+		}
+
+		os.Remove(exeFile)
+	}
+}
+
+// run assembles and links asm into exeFile and returns the exit code
+// of running it.
+func run(t *testing.T, asm []byte, exeFile string) int {
+	t.Helper()
+	args := []string{"-x", "assembler", "-", "-static", "-o", exeFile}
+	cmd := exec.Command("gcc", args...)
+	cmd.Stdin = bytes.NewReader(asm)
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Failed to build program: %v", err)
+	}
+
+	cmd = exec.Command(exeFile)
+	cmd.Run()
+	return cmd.ProcessState.ExitCode()
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.c":            "#include \"local.h\"\n#include \"local.h\"\n#include <lib.h>\n#define H \"sub/h.h\"\n#include H\nint main() { return LOCAL + LIB + H_VALUE; }\n",
+		"local.h":           "#pragma once\nint local;\n#define LOCAL 1\n",
+		"inc/lib.h":         "#define LIB 2\n#include \"lib2.h\"\n",
+		"inc/lib2.h":        "#include <stdbool.h>\n#include <stdio.h>\nint lib2() { return LIB2; }\n",
+		"sub/h.h":           "#define H_VALUE 4\n",
+		"inc/local.h":       "#error wrong local.h\n",
+		"inc/stdbool.h":     "#include_next <stdbool.h>\n#define LIB2 false\n",
+		"missing/missing.c": "#include \"nothere.h\"\n",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := Options{
+		Filename:     filepath.Join(dir, "main.c"),
+		IncludePaths: []string{filepath.Join(dir, "inc")},
+	}
+	included := []string{}
+	opts.Included = func(path string) { included = append(included, path) }
+	asm, err := Compile([]byte(files["main.c"]), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedIncluded := []string{
+		filepath.Join(dir, "local.h"),
+		filepath.Join(dir, "inc/lib.h"),
+		filepath.Join(dir, "inc/lib2.h"),
+		filepath.Join(dir, "inc/stdbool.h"),
+		filepath.Join(dir, "sub/h.h"),
+	}
+	if !reflect.DeepEqual(included, expectedIncluded) {
+		t.Errorf("included %v (expected: %v)", included, expectedIncluded)
+	}
+	if exitCode := run(t, asm, filepath.Join(dir, "tmp")); exitCode != 7 {
+		t.Errorf("got exit code %d (expected: 7)", exitCode)
+	}
+
+	_, err = Compile([]byte(files["missing/missing.c"]), Options{Filename: "missing.c"})
+	expected := "missing.c:1:10: error: nothere.h: No such file or directory"
+	if errs, ok := err.(ErrorList); !ok || errs[0].Error() != expected {
+		t.Errorf("got %v (expected: %v)", err, expected)
+	}
+}
+
+// TestSystemHeaders checks that headers of the host's C library parse,
+// both as written for any compiler and with the GNU extensions they use
+// when __GNUC__ is defined.
+//
+// The host's stdio.h, stdlib.h and ctype.h are not supported yet: they
+// need floating types, enums, constant expressions as array sizes and
+// the bitwise operators in glibc's inline functions.
+func TestSystemHeaders(t *testing.T) {
+	out, err := exec.Command("gcc", "-print-file-name=include").Output()
+	if err != nil {
+		t.Skip("gcc include directory not found")
+	}
+	if _, err := os.Stat("/usr/include/errno.h"); err != nil {
+		t.Skip("system headers not found")
+	}
+	includePaths := []string{strings.TrimSpace(string(out)), "/usr/include/x86_64-linux-gnu", "/usr/include"}
+	src := "#include <errno.h>\n#include <assert.h>\n#include <stdarg.h>\n#include <stdint.h>\n#include <limits.h>\n#include <alloca.h>\n#include <stdbool.h>\n#include <string.h>\n" +
+		"int main() { va_list ap; int32_t x = 0; return sizeof(ap) + x; }\n"
+
+	for _, macros := range [][]MacroDef{
+		nil,
+		{{Name: "__GNUC__", Value: "12"}, {Name: "__GNUC_MINOR__", Value: "2"}},
+	} {
+		asm, err := Compile([]byte(src), Options{IncludePaths: includePaths, Macros: macros})
+		if err != nil {
+			t.Fatalf("%v: %v", macros, err)
+		}
+		if exitCode := run(t, asm, filepath.Join(t.TempDir(), "tmp")); exitCode != 24 {
+			t.Errorf("%v: got exit code %d (expected: 24)", macros, exitCode)
+		}
+	}
+}
+
+func TestPreprocess(t *testing.T) {
+	type testData struct {
+		input    string
+		expected string
+		macros   []MacroDef
+	}
+	data := []testData{
+		// The examples from C11 6.10.3.5
+		{`#define x 3
+#define f(a) f(x * (a))
+#undef x
+#define x 2
+#define g f
+#define z z[0]
+#define h g(~
+#define m(a) a(w)
+#define w 0,1
+#define t(a) a
+#define p() int
+#define q(x) x
+#define r(x,y) x ## y
+#define str(x) # x
+f(y+1) + f(f(z)) % t(t(g)(0) + t)(1);
+g(x+(3,4)-w) | h 5) & m
+(f)^m(m);
+p() i[q()] = { q(1), r(2,3), r(4,), r(,5), r(,) };
+char c[2][6] = { str(hello), str() };`,
+			`f(2 * (y+1)) + f(2 * (f(2 * (z[0])))) % f(2 * (0)) + t(1);
+f(2 * (2+(3,4)-0,1)) | f(2 * (~ 5)) & f(2 * (0,1))^m(0,1);
+int i[] = { 1, 23, 4, 5, };
+char c[2][6] = { "hello", "" };`, nil},
+		{`#define hash_hash # ## #
+#define mkstr(a) # a
+#define in_between(a) mkstr(a)
+#define join(c, d) in_between(c hash_hash d)
+char p[] = join(x, y);`, `char p[] = "x ## y";`, nil},
+		{`#define showlist(...) puts(#__VA_ARGS__)
+#define report(test, ...) ((test)?puts(#test): printf(__VA_ARGS__))
+showlist(The first, second, and third items.);
+report(x>y, "x is %d but y is %d", x, y);`, `puts("The first, second, and third items.");
+((x>y)?puts("x>y"): printf("x is %d but y is %d", x, y));`, nil},
+		{`#define F(x, ...) f(x, ## __VA_ARGS__)
+F(1) F(1, 2)`, `f(1) f(1, 2)`, nil},
+		{`#define STR(x) #x
+STR("a\n" '"')`, `"\"a\\n\" '\"'"`, nil},
+		{`#if CMD == 5 && F(3) == 6
+a
+#endif
+#ifdef CMD
+b
+#else
+x
+#endif
+#ifndef NOPE
+c
+#elif 1/0
+x
+#endif`, "a\nb\nc", []MacroDef{{Name: "CMD", Value: "5"}, {Name: "F(x)", Value: "x*2"}}},
+		{`#define X
+#undef X
+#if 0
+# if garbage (
+#  error nope
+# else
+x
+# endif
+#elif defined(X) || defined X
+x
+#elif !defined(X) && defined __FOO__ == 0
+a
+#else
+x
+#endif`, "a", nil},
+		{`#if -1 > 0u && -1 < 0 && (1 ? -1 : 0u) > 0 && (-1 >> 63) == -1
+a
+#endif
+#if 0 && 1 / 0 || 2 % 1 == 0 && ~0 == -1 && 'a' == 97 && (3 << 2 | 1 ^ 2 & 3) == 15
+b
+#endif
+#if 1
+#if 0
+#elif 1
+c
+#elif 1
+x
+#else
+x
+#endif
+#endif`, "a\nb\nc", nil},
+		{`#if 0
+this isn't C, "nor this
+#else
+a
+#endif`, "a", nil},
+	}
+
+	for _, v := range data {
+		diag := newDiagnostics(Options{})
+		tok := newPreprocessor(diag, Options{Macros: v.macros}).preprocessFile(&File{Contents: v.input})
+		if diag.nerrors > 0 {
+			t.Errorf("%v: %v", v.input, diag.err())
+			continue
+		}
+		var b strings.Builder
+		for ; !tok.AtEOF(); tok = tok.next {
+			switch {
+			case tok.atBOL && b.Len() > 0:
+				b.WriteByte('\n')
+			case tok.hasSpace:
+				b.WriteByte(' ')
+			}
+			b.WriteString(tok.str)
+		}
+		if b.String() != v.expected {
+			t.Errorf("%v =>\n%v\n(expected:\n%v)", v.input, b.String(), v.expected)
+		}
+	}
+}
+
+func TestPreprocessOutput(t *testing.T) {
+	src := `#define ADD(a, b) a + b
+#define ID(x) x
+#define M -1
+#define EMPTY
+int main() {
+  return ADD(1,
+    2);
+  ID(
+      x) = -M + +M - ID(-)1;
+	EMPTY int y;
+ ID(ID(z));
+
+
+// The gap is short enough to be blank lines.
+#line 100 "foo.c"
+}`
+	// The same as gcc
+	expected := `# 5 "test.c"
+int main() {
+  return 1 + 2
+      ;
+  x
+         = - -1 + +-1 - -1;
+ int y;
+ z;
+# 100 "foo.c"
+}
+`
+	var b bytes.Buffer
+	if err := Preprocess(&b, []byte(src), Options{Filename: "test.c"}); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != expected {
+		t.Errorf("got:\n%s\n(expected:\n%s)", b.String(), expected)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	type testData struct {
+		input    string
+		expected []string
+	}
+	data := []testData{
+		{"returned iffy sizeofx int_ _Bool", []string{"returned", "iffy", "sizeofx", "int_", "_Bool"}},
+		{"a->b++ --c", []string{"a", "->", "b", "++", "--", "c"}},
+		{"x<<=y>>z...", []string{"x", "<<=", "y", ">>", "z", "..."}},
+		{"a&&b||c&d|e", []string{"a", "&&", "b", "||", "c", "&", "d", "|", "e"}},
+		{"<: :> <% %> %: %:%:", []string{"[", "]", "{", "}", "#", "##"}},
+	}
+
+	for _, v := range data {
+		diag := newDiagnostics(Options{})
+		actual := []string{}
+		for tok := Tokenize(&File{Contents: v.input}, diag); !tok.AtEOF(); tok = tok.next {
+			actual = append(actual, tok.str)
+		}
+		if strings.Join(actual, " ") != strings.Join(v.expected, " ") {
+			t.Errorf("%v => %q (expected: %q)", v.input, actual, v.expected)
+		}
+	}
+
+	diag := newDiagnostics(Options{})
+	tok := Tokenize(&File{Contents: "return2 int x"}, diag)
+	convertTokens(tok, diag)
+	if tok.kind != TK_IDENT || tok.next.kind != TK_RESERVED || tok.next.next.kind != TK_IDENT {
+		t.Errorf("keywords are not classified correctly")
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	type testData struct {
+		input    string
+		expected string
+	}
+	data := []testData{
+		{"int main() { return 0 }", "test.c:1:23: error: expected ';'"},
+		{"int main() { return x; }", "test.c:1:21: error: undefined variable 'x'"},
+		{"int main() {\n  return \"abc;\n}", "test.c:2:10: error: unclosed string literal"},
+		{"int main() { /* return 0; }", "test.c:1:14: error: unclosed block comment"},
+		{"int main() { int x; return *x; }", "test.c:1:28: error: invalid pointer dereference"},
+		{"int main() { 1 = 2; }", "test.c:1:14: error: not an lvalue"},
+		{"#foo\nint main() { return 0; }", "test.c:1:2: error: invalid preprocessing directive #foo"},
+		{"#define 1 2\n", "test.c:1:9: error: macro names must be identifiers"},
+		{"#undef X y\n", "test.c:1:10: error: extra tokens at end of #undef directive"},
+		{"#define X *1\nint main() { return X; }", "test.c:1:11: error: invalid pointer dereference"},
+		{"#define E(x, y) x\nE(1)", "test.c:2:1: error: macro \"E\" requires 2 arguments, but only 1 given"},
+		{"#define E(x) x\nE(1, 2)", "test.c:2:1: error: macro \"E\" passed 2 arguments, but takes just 1"},
+		{"#define E(x) x\nE(1", "test.c:2:1: error: unterminated argument list invoking macro \"E\""},
+		{"#define S(x) #y\n", "test.c:1:14: error: '#' is not followed by a macro parameter"},
+		{"#define P(a, b) a ## b\nP(+, /)", "test.c:2:3: error: pasting \"+\" and \"/\" does not give a valid preprocessing token"},
+		{"#if\n#endif", "test.c:1:2: error: #if with no expression"},
+		{"#if (1 + 2\n#endif", "test.c:1:11: error: missing ')' in expression"},
+		{"#if 1 2\n#endif", "test.c:1:7: error: missing binary operator before token \"2\""},
+		{"#if 1 / 0\n#endif", "test.c:1:7: error: division by zero in #if"},
+		{"#if defined(X\n#endif", "test.c:1:5: error: missing ')' after \"defined\""},
+		{"#if 1\n#else\n#else\n#endif", "test.c:3:2: error: #else after #else"},
+		{"#endif", "test.c:1:2: error: #endif without #if"},
+		{"#ifdef X\nint x;", "test.c:1:2: error: unterminated #ifdef"},
+		{"#line 10 \"foo.c\"\nint main() { return x; }", "foo.c:10:21: error: undefined variable 'x'"},
+		{"# 5 \"foo.c\" 1 3\nint main() { return x; }", "foo.c:5:21: error: undefined variable 'x'"},
+		{"#line x\n", "test.c:1:7: error: \"x\" after #line is not a positive integer"},
+		{"int main() { struct {int a;} x; return x.b; }", "test.c:1:42: error: no member named 'b'"},
+		{"typedef int T;\nint main() { return T; }", "test.c:2:21: error: expected expression before 'T'"},
+		{"int main() { void x; }", "test.c:1:19: error: variable or field 'x' declared void"},
+		{"void f() {}\nint main() { return f(); }", "test.c:2:21: error: void value not ignored as it ought to be"},
+		{"void f() {}\nint main() { int x; x = f() + 1; }", "test.c:2:25: error: void value not ignored as it ought to be"},
+		{"int main() { void *p; return *p; }", "test.c:1:30: error: void value not ignored as it ought to be"},
+		{"int main() { return (void)0; }", "test.c:1:21: error: void value not ignored as it ought to be"},
+		{"void f() { return 1; }", "test.c:1:12: error: 'return' with a value, in function returning void"},
+		{"int f() { return; }", "test.c:1:11: error: 'return' with no value, in function returning non-void"},
+		{"_Alignas(3) int x;", "test.c:1:10: error: requested alignment is not a positive power of 2"},
+		{"int f(_Alignas(8) int x) { return x; }", "test.c:1:7: error: _Alignas specified for a parameter"},
+		{"int main() { int x; return _Alignof(x); }", "test.c:1:28: error: _Alignof requires a type name"},
+		{"struct { static int x; } s;", "test.c:1:10: error: storage class specified for a parameter or member"},
+		{"struct S s;", "test.c:1:10: error: storage size of 's' isn't known"},
+		{"int main() { struct S *p; return p->x; }", "test.c:1:37: error: invalid use of undefined type 'struct S'"},
+		{"struct S; int main() { return sizeof(struct S); }", "test.c:1:31: error: invalid application of 'sizeof' to incomplete type 'struct S'"},
+		{"int main() { struct S *p; return sizeof(*p); }", "test.c:1:34: error: invalid application of 'sizeof' to incomplete type 'struct S'"},
+		{"struct S { struct S s; };", "test.c:1:21: error: field 's' has incomplete type"},
+		{"struct S { int a; }; struct S { int b; };", "test.c:1:29: error: redefinition of 'struct S'"},
+		{"struct S a[2];", "test.c:1:12: error: array type has incomplete element type 'struct S'"},
+		{"int main() { int x; return x->y; }", "test.c:1:29: error: invalid pointer dereference"},
+		{"int main() { int x; return x.y; }", "test.c:1:30: error: not a struct or union"},
+		{"struct S { int a; }; union S u;", "test.c:1:28: error: 'S' defined as wrong kind of tag"},
+		{"union U { int a; }; union U { int b; };", "test.c:1:27: error: redefinition of 'union U'"},
+		{"union U u;", "test.c:1:9: error: storage size of 'u' isn't known"},
+		{"int main() { int a[1] = {1, 2}; }", "test.c:1:29: error: excess elements in initializer"},
+		{"int main() { int a[1] = 1; }", "test.c:1:25: error: invalid initializer"},
+		{"int main() { return (int x)0; }", "test.c:1:26: error: expected ')' before 'x'"},
+		{"int int x;", "test.c:1:5: error: two or more data types in declaration specifiers"},
+		{"double x;", "test.c:1:1: error: floating types are not supported"},
+		{"int f(int) { return 0; }", "test.c:1:5: error: parameter name omitted"},
+		{"int main() { int a[3]; a = 0; return 0; }", "test.c:1:26: error: assignment to expression with array type"},
+		{"int main() { __func__ = 0; return 0; }", "test.c:1:23: error: assignment to expression with array type"},
+		{"int x; int y = x;", "test.c:1:12: error: initializer element is not constant"},
+		{"int main() { int x; return x(1); }", "test.c:1:29: error: called object is not a function or function pointer"},
+		{"struct S { int a; } s; struct T { int a; } t; int main() { s = t; return 0; }", "test.c:1:62: error: incompatible types when assigning to type 'struct S' from type 'struct T'"},
+		{"struct S { int a; } s; struct S f(); int main() { s = f(); return 0; }", "test.c:1:53: error: assigning a 'struct S' from this expression is not supported"},
+		{"int f(int n) { __builtin_va_list ap; __builtin_va_start(ap, n); return 0; }", "test.c:1:38: error: 'va_start' used in function with fixed arguments"},
+		{"int f(int n, ...) { int ap; __builtin_va_end(ap); return 0; }", "test.c:1:29: error: argument to '__builtin_va_end' is not of type 'va_list'"},
+		{"int f(int n, ...) { __builtin_va_list ap; __builtin_va_arg(ap, struct S { int x; }); return 0; }", "test.c:1:43: error: 'va_arg' of type 'struct S' is not supported"},
+		{"int x __asm__(\"y\");", "test.c:1:5: error: assembler labels are only supported on functions"},
+		{"int main() { return 0; } @", "test.c:1:26: error: invalid token"},
+		{"int main() { return ''; }", "test.c:1:21: error: empty character constant"},
+		{"int main() { return 'a; }", "test.c:1:21: error: missing terminating ' character"},
+		{"int main() { return 'ab\n; }", "test.c:1:21: error: missing terminating ' character"},
+		{"int main() { return '\\x'; }", "test.c:1:22: error: \\x used with no following hex digits"},
+		{"int main() { return 'abcde'; }", "test.c:1:21: error: character constant too long for its type"},
+		{"int main() { return \"\\u00e\"; }", "test.c:1:22: error: incomplete universal character name \\u00e"},
+		{"int main() { return \"\\u0041\"; }", "test.c:1:22: error: \\u0041 is not a valid universal character"},
+		{"int main() { return \"\\U0000d800\"; }", "test.c:1:22: error: \\U0000d800 is not a valid universal character"},
+		{"int main() { return 123abc; }", "test.c:1:21: error: invalid suffix \"abc\" on integer constant"},
+		{"int main() { return 10lL; }", "test.c:1:21: error: invalid suffix \"lL\" on integer constant"},
+		{"int main() { return 0x; }", "test.c:1:21: error: invalid suffix \"x\" on integer constant"},
+		{"int main() { return 089; }", "test.c:1:21: error: invalid digit \"8\" in octal constant"},
+		{"int main() { return 0b12; }", "test.c:1:21: error: invalid digit \"2\" in binary constant"},
+		{"int main() { return 1.5; }", "test.c:1:21: error: floating constants are not supported"},
+		{"int main() { return 18446744073709551616; }", "test.c:1:21: error: integer constant is too large for its type"},
+		{"int main() { return 9223372036854775808; }", "test.c:1:21: error: integer constant is too large for its type"},
+	}
+
+	for _, v := range data {
+		_, err := Compile([]byte(v.input), Options{Filename: "test.c"})
+		if _, ok := err.(ErrorList); !ok {
+			t.Errorf("%v: expected ErrorList, got %#v", v.input, err)
+			continue
+		}
+		if actual := err.(ErrorList)[0].Error(); actual != v.expected {
+			t.Errorf("%v => %q (expected: %q)", v.input, actual, v.expected)
+		}
+	}
+}
+
+func TestErrorSnippet(t *testing.T) {
+	_, err := Compile([]byte("int main() {\n\treturn 0\n}"), Options{Filename: "test.c"})
+	errs, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected ErrorList, got %#v", err)
+	}
+	e := errs[0]
+	expected := "    3 | }\n      | ^\n"
+	if e.Snippet() != expected {
+		t.Errorf("got %q (expected: %q)", e.Snippet(), expected)
+	}
+}
+
+func TestCompileMultipleErrors(t *testing.T) {
+	type testData struct {
+		input     string
+		maxErrors int
+		expected  []string
+	}
+	data := []testData{
+		{"int main() { int x = ; x = 1 @; return y; }", 0, []string{
+			"1:22: error: expected a number",
+			"1:30: error: invalid token",
+			"1:40: error: undefined variable 'y'",
+		}},
+		{"int f( { return 1; } int main() { *1; return 0 } int g() { return 1; }", 0, []string{
+			"1:8: error: expected declaration specifiers",
+			"1:35: error: invalid pointer dereference",
+			"1:48: error: expected ';'",
+		}},
+		{"int main() { x; y; z; }", 2, []string{
+			"1:14: error: undefined variable 'x'",
+			"1:17: error: undefined variable 'y'",
+		}},
+		{"int main() { if (1) { return 1;", 0, []string{
+			"1:32: error: expected '}'",
+		}},
+		{"int main() { int a[2] = {1, 2, 3}; return 0; }", 0, []string{
+			"1:32: error: excess elements in initializer",
+		}},
+		{"int main() { int a[1][1] = {{1, 2}}; int b[1] = {x}; return y; }", 0, []string{
+			"1:33: error: excess elements in initializer",
+			"1:50: error: undefined variable 'x'",
+			"1:61: error: undefined variable 'y'",
+		}},
+	}
+
+	for _, v := range data {
+		_, err := Compile([]byte(v.input), Options{MaxErrors: v.maxErrors})
+		errs, ok := err.(ErrorList)
+		if !ok {
+			t.Errorf("%v: expected ErrorList, got %#v", v.input, err)
+			continue
+		}
+		actual := []string{}
+		for _, e := range errs {
+			actual = append(actual, e.Error())
+		}
+		if strings.Join(actual, "\n") != strings.Join(v.expected, "\n") {
+			t.Errorf("%v =>\n%v\n(expected:\n%v)", v.input, strings.Join(actual, "\n"), strings.Join(v.expected, "\n"))
+		}
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	type testData struct {
+		input    string
+		warnings map[string]bool
+		werror   bool
+		expected []string
+	}
+	all := map[string]bool{}
+	for _, name := range KnownWarnings {
+		all[name] = true
+	}
+	data := []testData{
+		{"int main() { int x; int y; y=1; return 0; }", all, false, []string{
+			"1:18: warning: unused variable 'x' [-Wunused-variable]",
+		}},
+		{"int f(int x, int y) { return y; } int main() { return f(1,2); }", all, false, []string{
+			"1:11: warning: unused parameter 'x' [-Wunused-parameter]",
+		}},
+		{"int f() { if (1) return 1; } int main() { return f(); }", nil, false, []string{
+			"1:28: warning: control reaches end of non-void function [-Wreturn-type]",
+		}},
+		{"int f() { if (1) return 1; else return 2; } int g() { for (;;) {} } int main() { }", nil, false, nil},
+		{"int f() { if (1) return 1; } int main() { return f(); }", map[string]bool{WarnReturnType: false}, false, nil},
+		{"int f() { return 1 +; } int main() { return f(); }", nil, false, []string{
+			"1:21: error: expected a number",
+		}},
+		{"int main() { return 0; 1; 2; }", all, false, []string{
+			"1:24: warning: statement is unreachable [-Wunreachable-code]",
+		}},
+		{"int x; int main(int y) { int z=y; { int x=3; int y=4; int z=5; z=x+y+z; } return z; }", all, false, []string{
+			"1:41: warning: declaration of 'x' shadows a global declaration [-Wshadow]",
+			"1:50: warning: declaration of 'y' shadows a parameter [-Wshadow]",
+			"1:59: warning: declaration of 'z' shadows a previous local [-Wshadow]",
+		}},
+		{"int main() { return 'ab'; }", nil, false, []string{
+			"1:21: warning: multi-character character constant [-Wmultichar]",
+		}},
+		{"int main() { return 'abc\n; }", nil, false, []string{
+			"1:21: error: missing terminating ' character",
+		}},
+		{"int main() { int x; return 0; }", map[string]bool{WarnUnusedVariable: true}, true, []string{
+			"1:18: error: unused variable 'x' [-Werror=unused-variable]",
+		}},
+	}
+
+	for _, v := range data {
+		actual := []string{}
+		report := func(d *Diagnostic) {
+			actual = append(actual, d.Error())
+		}
+		opts := Options{Warnings: v.warnings, WarningsAsErrors: v.werror, Report: report}
+		_, err := Compile([]byte(v.input), opts)
+		if (err != nil) != strings.Contains(strings.Join(v.expected, "\n"), ": error: ") {
+			t.Errorf("%v: unexpected error: %v", v.input, err)
+		}
+		if strings.Join(actual, "\n") != strings.Join(v.expected, "\n") {
+			t.Errorf("%v =>\n%v\n(expected:\n%v)", v.input, strings.Join(actual, "\n"), strings.Join(v.expected, "\n"))
+		}
+	}
+}
+
+func TestDiagnosticFormats(t *testing.T) {
+	var diags []*Diagnostic
+	opts := Options{
+		Filename: "test.c",
+		Warnings: map[string]bool{WarnShadow: true},
+		Report:   func(d *Diagnostic) { diags = append(diags, d) },
+	}
+	Compile([]byte("int x; int main() { int x; x=1; return y; }"), opts)
+
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, diags); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]interface{}{
+		{
+			"severity": "warning",
+			"code":     "shadow",
+			"message":  "declaration of 'x' shadows a global declaration",
+			"range": map[string]interface{}{
+				"start": map[string]interface{}{"file": "test.c", "offset": 24.0, "line": 1.0, "column": 25.0},
+				"end":   map[string]interface{}{"file": "test.c", "offset": 25.0, "line": 1.0, "column": 26.0},
+			},
+			"notes": []interface{}{
+				map[string]interface{}{
+					"severity": "note",
+					"message":  "shadowed declaration is here",
+					"range": map[string]interface{}{
+						"start": map[string]interface{}{"file": "test.c", "offset": 4.0, "line": 1.0, "column": 5.0},
+						"end":   map[string]interface{}{"file": "test.c", "offset": 5.0, "line": 1.0, "column": 6.0},
+					},
+				},
+			},
+		},
+		{
+			"severity": "error",
+			"message":  "undefined variable 'y'",
+			"range": map[string]interface{}{
+				"start": map[string]interface{}{"file": "test.c", "offset": 39.0, "line": 1.0, "column": 40.0},
+				"end":   map[string]interface{}{"file": "test.c", "offset": 40.0, "line": 1.0, "column": 41.0},
+			},
+		},
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("JSON output:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteSARIF(&buf, diags); err != nil {
+		t.Fatal(err)
+	}
+	var log struct {
+		Version string
+		Runs    []struct {
+			Results []struct {
+				RuleID    string
+				Level     string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, StartColumn, EndLine, EndColumn int }
+					}
+				}
+				RelatedLocations []struct{ Message struct{ Text string } }
+			}
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 || len(log.Runs[0].Results) != 2 {
+		t.Fatalf("unexpected SARIF output:\n%s", buf.String())
+	}
+	r := log.Runs[0].Results[0]
+	loc := r.Locations[0].PhysicalLocation
+	if r.RuleID != "shadow" || r.Level != "warning" || loc.ArtifactLocation.URI != "test.c" ||
+		loc.Region.StartColumn != 25 || loc.Region.EndColumn != 26 ||
+		len(r.RelatedLocations) != 1 || r.RelatedLocations[0].Message.Text != "shadowed declaration is here" {
+		t.Errorf("unexpected SARIF output:\n%s", buf.String())
+	}
+	if r := log.Runs[0].Results[1]; r.RuleID != "" || r.Level != "error" {
+		t.Errorf("unexpected SARIF output:\n%s", buf.String())
+	}
+}
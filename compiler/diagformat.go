@@ -0,0 +1,144 @@
+package compiler
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type jsonRange struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// MarshalJSON encodes the diagnostic as an object with its severity,
+// code, message, range and notes.
+func (d *Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Severity Severity      `json:"severity"`
+		Code     string        `json:"code,omitempty"`
+		Message  string        `json:"message"`
+		Range    jsonRange     `json:"range"`
+		Notes    []*Diagnostic `json:"notes,omitempty"`
+	}{
+		Severity: d.Severity,
+		Code:     d.Code,
+		Message:  d.Msg,
+		Range:    jsonRange{Start: d.Pos, End: d.End},
+		Notes:    d.Notes,
+	})
+}
+
+// WriteJSON writes diags to w as a JSON array, as for
+// -fdiagnostics-format=json.
+func WriteJSON(w io.Writer, diags []*Diagnostic) error {
+	if diags == nil {
+		diags = []*Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diags)
+}
+
+// The subset of the SARIF 2.1.0 object model used by WriteSARIF.
+type (
+	sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name  string      `json:"name"`
+		Rules []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID string `json:"id"`
+	}
+	sarifResult struct {
+		RuleID           string          `json:"ruleId,omitempty"`
+		Level            string          `json:"level"`
+		Message          sarifMessage    `json:"message"`
+		Locations        []sarifLocation `json:"locations"`
+		RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		Message          *sarifMessage         `json:"message,omitempty"`
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           sarifRegion           `json:"region"`
+	}
+	sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+		EndLine     int `json:"endLine"`
+		EndColumn   int `json:"endColumn"`
+	}
+)
+
+func sarifLocationOf(d *Diagnostic) sarifLocation {
+	return sarifLocation{
+		PhysicalLocation: sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifactLocation{URI: d.Pos.Filename},
+			Region: sarifRegion{
+				StartLine:   d.Pos.Line,
+				StartColumn: d.Pos.Column,
+				EndLine:     d.End.Line,
+				EndColumn:   d.End.Column,
+			},
+		},
+	}
+}
+
+// WriteSARIF writes diags to w as a SARIF 2.1.0 log, as for
+// -fdiagnostics-format=sarif. Each warning's code is its rule ID and
+// notes become related locations.
+func WriteSARIF(w io.Writer, diags []*Diagnostic) error {
+	rules := []sarifRule{}
+	for _, name := range KnownWarnings {
+		rules = append(rules, sarifRule{ID: name})
+	}
+
+	results := []sarifResult{}
+	for _, d := range diags {
+		r := sarifResult{
+			RuleID:    d.Code,
+			Level:     d.Severity.String(),
+			Message:   sarifMessage{Text: d.Msg},
+			Locations: []sarifLocation{sarifLocationOf(d)},
+		}
+		for _, n := range d.Notes {
+			loc := sarifLocationOf(n)
+			loc.Message = &sarifMessage{Text: n.Msg}
+			r.RelatedLocations = append(r.RelatedLocations, loc)
+		}
+		results = append(results, r)
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool: sarifTool{
+				Driver: sarifDriver{Name: "gocc", Rules: rules},
+			},
+			Results: results,
+		}},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(log)
+}
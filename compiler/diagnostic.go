@@ -0,0 +1,356 @@
+package compiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Position is a location in a source file.
+type Position struct {
+	Filename string `json:"file"`
+	// Offset is the byte offset, starting at 0.
+	Offset int `json:"offset"`
+	// Line is the line number, starting at 1.
+	Line int `json:"line"`
+	// Column is the byte column in the line, starting at 1.
+	Column int `json:"column"`
+}
+
+func (p Position) String() string {
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// Severity is the severity of a diagnostic.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityNote
+)
+
+// MarshalText encodes the severity as its name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	case SeverityNote:
+		return "note"
+	}
+	return "error"
+}
+
+// Names of warnings, as used in -W<name> and -Wno-<name>.
+const (
+	WarnUnusedVariable  = "unused-variable"
+	WarnUnusedParameter = "unused-parameter"
+	WarnReturnType      = "return-type"
+	WarnUnreachableCode = "unreachable-code"
+	WarnShadow          = "shadow"
+	WarnMultichar       = "multichar"
+)
+
+// KnownWarnings lists the names of all warnings.
+var KnownWarnings = []string{
+	WarnUnusedVariable,
+	WarnUnusedParameter,
+	WarnReturnType,
+	WarnUnreachableCode,
+	WarnShadow,
+	WarnMultichar,
+}
+
+// defaultWarnings are the warnings enabled unless Options.Warnings says
+// otherwise.
+var defaultWarnings = map[string]bool{
+	WarnReturnType: true,
+	WarnMultichar:  true,
+}
+
+// Diagnostic is an error, warning or note about the source program.
+type Diagnostic struct {
+	Severity Severity
+	// Code is the name of the warning that produced the diagnostic, or
+	// empty for errors and notes.
+	Code string
+	// Pos is where the diagnostic starts and End where it ends, which
+	// is the same as Pos if it has no extent.
+	Pos Position
+	End Position
+	Msg string
+	// SourceLine is the text of the line containing Pos.
+	SourceLine string
+	// Notes give additional information about the diagnostic, such as
+	// the location of a related declaration.
+	Notes []*Diagnostic
+}
+
+func (d *Diagnostic) Error() string {
+	switch {
+	case d.Code == "":
+		return fmt.Sprintf("%s: %s: %s", d.Pos, d.Severity, d.Msg)
+	case d.Severity == SeverityError:
+		return fmt.Sprintf("%s: error: %s [-Werror=%s]", d.Pos, d.Msg, d.Code)
+	}
+	return fmt.Sprintf("%s: %s: %s [-W%s]", d.Pos, d.Severity, d.Msg, d.Code)
+}
+
+// Snippet returns the source line of the diagnostic followed by a line
+// with a caret under its column and the rest of its extent on that line
+// underlined, in the style of gcc. Printed after the diagnostic itself,
+// it looks like:
+//
+//	file.c:12:7: warning: unused variable 'foo' [-Wunused-variable]
+//	   12 |   int foo;
+//	      |       ^~~
+func (d *Diagnostic) Snippet() string {
+	lineNo := fmt.Sprintf("%5d", d.Pos.Line)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s | %s\n", lineNo, d.SourceLine)
+	fmt.Fprintf(&b, "%s | ", strings.Repeat(" ", len(lineNo)))
+	for i := 0; i < d.Pos.Column-1 && i < len(d.SourceLine); i++ {
+		if d.SourceLine[i] == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteByte('^')
+	if d.End.Line == d.Pos.Line && d.End.Column > d.Pos.Column+1 {
+		b.WriteString(strings.Repeat("~", d.End.Column-d.Pos.Column-1))
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// Render returns the diagnostic as gcc would print it: the message,
+// the snippet and then each note in the same form.
+func (d *Diagnostic) Render() string {
+	var b strings.Builder
+	b.WriteString(d.Error())
+	b.WriteByte('\n')
+	b.WriteString(d.Snippet())
+	for _, n := range d.Notes {
+		b.WriteString(n.Render())
+	}
+	return b.String()
+}
+
+// ErrorList is a list of errors, sorted by position.
+type ErrorList []*Diagnostic
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// diagnostics collects the diagnostics reported during a compilation.
+type diagnostics struct {
+	list    []*Diagnostic
+	nerrors int
+	// max is the number of errors after which compilation stops, or 0
+	// for no limit.
+	max int
+
+	warnings map[string]bool
+	werror   bool
+}
+
+// tooManyErrors is panicked by diagnostics.add when the error limit is
+// reached. It is recovered by Compile.
+type tooManyErrors struct{}
+
+func newDiagnostics(opts Options) *diagnostics {
+	warnings := map[string]bool{}
+	for name, on := range defaultWarnings {
+		warnings[name] = on
+	}
+	for name, on := range opts.Warnings {
+		warnings[name] = on
+	}
+	return &diagnostics{
+		max:      opts.MaxErrors,
+		warnings: warnings,
+		werror:   opts.WarningsAsErrors,
+	}
+}
+
+func (c *diagnostics) add(d *Diagnostic) {
+	// An error at the same place as the previous one is almost always a
+	// consequence of it.
+	if n := len(c.list); n > 0 && c.list[n-1].Pos == d.Pos && d.Severity == SeverityError {
+		return
+	}
+	c.list = append(c.list, d)
+	if d.Severity != SeverityError {
+		return
+	}
+	c.nerrors++
+	if c.max > 0 && c.nerrors >= c.max {
+		panic(tooManyErrors{})
+	}
+}
+
+// warn reports the warning named code at tok, if it is enabled. Notes
+// may be attached to the returned diagnostic, which is nil if the
+// warning is disabled.
+func (c *diagnostics) warn(tok *Token, code string, format string, a ...interface{}) *Diagnostic {
+	if !c.warnings[code] {
+		return nil
+	}
+	d := tokenError(tok, format, a...)
+	d.Code = code
+	if !c.werror {
+		d.Severity = SeverityWarning
+	}
+	c.add(d)
+	return d
+}
+
+// try calls f, recording the error it panics with, if any. It reports
+// whether f completed without an error.
+func (c *diagnostics) try(f func()) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			d, isErr := r.(*Diagnostic)
+			if !isErr {
+				panic(r)
+			}
+			ok = false
+			c.add(d)
+		}
+	}()
+	f()
+	return true
+}
+
+// sort sorts the diagnostics by position. Diagnostics in different files
+// are kept in the order the files were first reported.
+func (c *diagnostics) sort() {
+	rank := map[string]int{}
+	for _, d := range c.list {
+		if _, ok := rank[d.Pos.Filename]; !ok {
+			rank[d.Pos.Filename] = len(rank)
+		}
+	}
+	sort.SliceStable(c.list, func(i, j int) bool {
+		a, b := c.list[i].Pos, c.list[j].Pos
+		if a.Filename != b.Filename {
+			return rank[a.Filename] < rank[b.Filename]
+		}
+		return a.Offset < b.Offset
+	})
+}
+
+// finish ends a compilation. It recovers r, the result of recover, if
+// the error limit was reached, passes the diagnostics to report in order
+// of position and returns the errors, if any.
+func (c *diagnostics) finish(r interface{}, report func(*Diagnostic)) error {
+	if r != nil {
+		if _, ok := r.(tooManyErrors); !ok {
+			panic(r)
+		}
+	}
+	c.sort()
+	if report != nil {
+		for _, d := range c.list {
+			report(d)
+		}
+	}
+	return c.err()
+}
+
+// err returns the errors among the diagnostics, or nil if there are
+// none.
+func (c *diagnostics) err() error {
+	if c.nerrors == 0 {
+		return nil
+	}
+	errs := ErrorList{}
+	for _, d := range c.list {
+		if d.Severity == SeverityError {
+			errs = append(errs, d)
+		}
+	}
+	return errs
+}
+
+// newNote returns a note at tok.
+func newNote(tok *Token, format string, a ...interface{}) *Diagnostic {
+	d := tokenError(tok, format, a...)
+	d.Severity = SeverityNote
+	return d
+}
+
+// newError returns an error at the byte offset pos in file.
+func newError(file *File, pos int, format string, a ...interface{}) *Diagnostic {
+	return newErrorRange(file, pos, pos, format, a...)
+}
+
+// tokenError returns an error spanning tok. If tok came from a macro
+// expansion, notes show where each enclosing macro was used.
+func tokenError(tok *Token, format string, a ...interface{}) *Diagnostic {
+	d := newErrorRange(tok.file, tok.pos, tok.pos+tok.len, format, a...)
+	for o := tok.origin; o != nil; o = o.origin {
+		note := newErrorRange(o.file, o.pos, o.pos+o.len, "in expansion of macro '%s'", o.str)
+		note.Severity = SeverityNote
+		d.Notes = append(d.Notes, note)
+	}
+	return d
+}
+
+// newErrorRange returns an error spanning the bytes from start up to end
+// in file.
+func newErrorRange(file *File, start int, end int, format string, a ...interface{}) *Diagnostic {
+	lineStart := strings.LastIndexByte(file.Contents[:start], '\n') + 1
+	lineEnd := strings.IndexByte(file.Contents[start:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(file.Contents)
+	} else {
+		lineEnd += start
+	}
+	return &Diagnostic{
+		Severity:   SeverityError,
+		Pos:        file.position(start),
+		End:        file.position(end),
+		Msg:        fmt.Sprintf(format, a...),
+		SourceLine: file.Contents[lineStart:lineEnd],
+	}
+}
+
+// position returns the position of the byte offset pos in f, with the
+// file name and line number as set by #line.
+func (f *File) position(pos int) Position {
+	lineStart := strings.LastIndexByte(f.Contents[:pos], '\n') + 1
+	name, line := f.presumed(pos, strings.Count(f.Contents[:lineStart], "\n")+1)
+	return Position{
+		Filename: name,
+		Offset:   pos,
+		Line:     line,
+		Column:   pos - lineStart + 1,
+	}
+}
+
+// errorAt aborts the current construct with an error at the byte offset
+// pos in file. It is recovered by diagnostics.try.
+func errorAt(file *File, pos int, format string, a ...interface{}) {
+	panic(newError(file, pos, format, a...))
+}
+
+// errorToken aborts the current construct with an error spanning tok.
+func errorToken(tok *Token, format string, a ...interface{}) {
+	panic(tokenError(tok, format, a...))
+}
@@ -0,0 +1,40 @@
+#ifndef __STDIO_H
+#define __STDIO_H
+
+#include <stddef.h>
+#include <stdarg.h>
+
+typedef struct _IO_FILE FILE;
+
+#define EOF (-1)
+
+extern FILE *stdin;
+extern FILE *stdout;
+extern FILE *stderr;
+
+FILE *fopen(const char *__filename, const char *__mode);
+int fclose(FILE *__stream);
+int fflush(FILE *__stream);
+size_t fread(void *__ptr, size_t __size, size_t __n, FILE *__stream);
+size_t fwrite(const void *__ptr, size_t __size, size_t __n, FILE *__stream);
+
+int printf(const char *__format, ...);
+int fprintf(FILE *__stream, const char *__format, ...);
+int sprintf(char *__s, const char *__format, ...);
+int snprintf(char *__s, size_t __n, const char *__format, ...);
+int vprintf(const char *__format, va_list __ap);
+int vfprintf(FILE *__stream, const char *__format, va_list __ap);
+int vsprintf(char *__s, const char *__format, va_list __ap);
+int vsnprintf(char *__s, size_t __n, const char *__format, va_list __ap);
+
+int fgetc(FILE *__stream);
+int getchar(void);
+char *fgets(char *__s, int __n, FILE *__stream);
+int fputc(int __c, FILE *__stream);
+int putchar(int __c);
+int fputs(const char *__s, FILE *__stream);
+int puts(const char *__s);
+
+void perror(const char *__s);
+
+#endif
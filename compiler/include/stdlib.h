@@ -0,0 +1,25 @@
+#ifndef __STDLIB_H
+#define __STDLIB_H
+
+#include <stddef.h>
+
+#define EXIT_SUCCESS 0
+#define EXIT_FAILURE 1
+#define RAND_MAX 2147483647
+
+void *malloc(size_t __size);
+void *calloc(size_t __n, size_t __size);
+void *realloc(void *__ptr, size_t __size);
+void free(void *__ptr);
+
+void exit(int __status);
+void abort(void);
+
+int atoi(const char *__nptr);
+int abs(int __x);
+int rand(void);
+void srand(unsigned __seed);
+char *getenv(const char *__name);
+int system(const char *__command);
+
+#endif
@@ -0,0 +1,1216 @@
+package compiler
+
+type Node interface {
+	Gen(g *codegen)
+	AddType()
+	Type() Type
+}
+
+type AddressGenerator interface {
+	Node
+	GenAddr(g *codegen)
+}
+
+type Unary interface {
+	Node
+}
+
+type BinaryNode interface {
+	Node
+	Lhs() Node
+	Rhs() Node
+	ApplyOperation()
+}
+
+type Binary struct {
+	BinaryNode
+	lhs Node
+	rhs Node
+	ty  Type
+}
+
+func (b *Binary) Lhs() Node {
+	return b.lhs
+}
+
+func (b *Binary) Rhs() Node {
+	return b.rhs
+}
+
+// typeOperands types the operands of b, whose values are used.
+func (b *Binary) typeOperands() {
+	b.lhs.AddType()
+	b.rhs.AddType()
+	checkValue(b.lhs)
+	checkValue(b.rhs)
+}
+
+// AddType applies the usual arithmetic conversions to integer operands,
+// which converts both to their common type, the type of the result.
+func (b *Binary) AddType() {
+	b.typeOperands()
+	b.ty = commonType(b.lhs.Type(), b.rhs.Type())
+	if isInteger(b.lhs.Type()) && isInteger(b.rhs.Type()) {
+		b.lhs = convert(b.lhs, b.ty)
+		b.rhs = convert(b.rhs, b.ty)
+	}
+}
+
+func (b Binary) Type() Type {
+	return b.ty
+}
+
+// func (b *Binary) ApplyOperation() {}
+
+type Add struct {
+	*Binary
+}
+
+func NewAdd(lhs Node, rhs Node) *Add {
+	return &Add{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+// AddType makes the pointer the left operand of pointer arithmetic, so
+// that "1 + p" is generated as "p + 1".
+func (a *Add) AddType() {
+	a.typeOperands()
+	if pointerBase(a.lhs.Type()) == nil && pointerBase(a.rhs.Type()) != nil {
+		a.lhs, a.rhs = a.rhs, a.lhs
+	}
+	if base := pointerBase(a.lhs.Type()); base != nil {
+		a.rhs = convert(a.rhs, longType)
+		a.ty = NewPointerType(base)
+		return
+	}
+	a.Binary.AddType()
+}
+
+type PointerAdd struct {
+	*Binary
+}
+
+func NewPointerAdd(lhs Node, rhs Node) *PointerAdd {
+	return &PointerAdd{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+func (p *PointerAdd) AddType() {
+	p.Binary.AddType()
+}
+
+type Sub struct {
+	*Binary
+}
+
+func NewSub(lhs Node, rhs Node) *Sub {
+	return &Sub{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+// AddType types the difference of two pointers as long, the number of
+// elements between them, and a pointer minus an integer as the pointer.
+func (s *Sub) AddType() {
+	s.typeOperands()
+	base := pointerBase(s.lhs.Type())
+	switch {
+	case base != nil && pointerBase(s.rhs.Type()) != nil:
+		s.ty = longType
+	case base != nil:
+		s.rhs = convert(s.rhs, longType)
+		s.ty = NewPointerType(base)
+	default:
+		s.Binary.AddType()
+	}
+}
+
+type PointerSub struct {
+	*Binary
+}
+
+func NewPointterSub(lhs Node, rhs Node) *PointerSub {
+	return &PointerSub{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+type PointerDiff struct {
+	*Binary
+}
+
+func NewPointterDiff(lhs Node, rhs Node) *PointerDiff {
+	return &PointerDiff{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+type Mul struct {
+	*Binary
+}
+
+func NewMul(lhs Node, rhs Node) *Mul {
+	return &Mul{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+type Div struct {
+	*Binary
+}
+
+func NewDiv(lhs Node, rhs Node) *Div {
+	return &Div{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+// Shl and Shr are the shift operators, whose result has the promoted
+// type of the left operand.
+type Shl struct {
+	*Binary
+}
+
+func NewShl(lhs Node, rhs Node) *Shl {
+	return &Shl{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+func (s *Shl) AddType() {
+	s.shiftType()
+}
+
+// shiftType promotes the operands of a shift separately: the type of the
+// right operand does not affect the result.
+func (b *Binary) shiftType() {
+	b.typeOperands()
+	b.ty = promote(b.lhs.Type())
+	b.lhs = convert(b.lhs, b.ty)
+	b.rhs = convert(b.rhs, promote(b.rhs.Type()))
+}
+
+type Shr struct {
+	*Binary
+}
+
+func NewShr(lhs Node, rhs Node) *Shr {
+	return &Shr{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+func (s *Shr) AddType() {
+	s.shiftType()
+}
+
+type Equal struct {
+	*Binary
+}
+
+func NewEqual(lhs Node, rhs Node) *Equal {
+	return &Equal{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+// compareType converts the operands of a comparison to their common
+// type. The result is an int.
+func (b *Binary) compareType() {
+	b.AddType()
+	b.ty = intType
+}
+
+func (e *Equal) AddType() {
+	e.compareType()
+}
+
+type NotEqual struct {
+	*Binary
+}
+
+func NewNotEqual(lhs Node, rhs Node) *NotEqual {
+	return &NotEqual{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+func (n *NotEqual) AddType() {
+	n.compareType()
+}
+
+type LessThan struct {
+	*Binary
+}
+
+func NewLessThan(lhs Node, rhs Node) *LessThan {
+	return &LessThan{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+func (l *LessThan) AddType() {
+	l.compareType()
+}
+
+type LessEqual struct {
+	*Binary
+}
+
+func NewLessEqual(lhs Node, rhs Node) *LessEqual {
+	return &LessEqual{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+func (l *LessEqual) AddType() {
+	l.compareType()
+}
+
+// LogAnd and LogOr are the logical operators, which evaluate the right
+// operand only if the left one does not decide the result. The result
+// is an int, 0 or 1.
+type LogAnd struct {
+	*Binary
+}
+
+func NewLogAnd(lhs Node, rhs Node) *LogAnd {
+	return &LogAnd{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+func (l *LogAnd) AddType() {
+	l.typeOperands()
+	l.ty = intType
+}
+
+type LogOr struct {
+	*Binary
+}
+
+func NewLogOr(lhs Node, rhs Node) *LogOr {
+	return &LogOr{
+		&Binary{
+			lhs: lhs,
+			rhs: rhs,
+		},
+	}
+}
+
+func (l *LogOr) AddType() {
+	l.typeOperands()
+	l.ty = intType
+}
+
+// Not is the ! operator, whose result is an int, 1 if expr compares
+// equal to 0 and 0 otherwise.
+type Not struct {
+	Unary
+	expr Node
+}
+
+func NewNot(expr Node) *Not {
+	return &Not{
+		expr: expr,
+	}
+}
+
+func (n *Not) AddType() {
+	n.expr.AddType()
+	checkValue(n.expr)
+}
+
+func (n *Not) Type() Type {
+	return intType
+}
+
+// Cast converts the value of an expression to the type ty. The casts
+// for the implicit conversions are inserted by AddType after their
+// expression has been typed, and have no token.
+type Cast struct {
+	expr Node
+	ty   Type
+	tok  *Token
+}
+
+func NewCast(expr Node, ty Type, tok *Token) *Cast {
+	return &Cast{
+		expr: expr,
+		ty:   ty,
+		tok:  tok,
+	}
+}
+
+// AddType types the expression of an explicit cast. A cast to void
+// discards any value.
+func (c *Cast) AddType() {
+	c.expr.AddType()
+	if _, ok := c.ty.(*VoidType); !ok {
+		checkValue(c.expr)
+	}
+}
+
+func (c *Cast) Type() Type {
+	return c.ty
+}
+
+// convert returns node converted to the integer type ty. Other types are
+// left as they are, except that pointers convert to _Bool.
+func convert(node Node, ty Type) Node {
+	from := node.Type()
+	_, toBool := ty.(*BoolType)
+	if from == ty || !isInteger(ty) {
+		return node
+	}
+	if !isInteger(from) && !(toBool && pointerBase(from) != nil) {
+		return node
+	}
+	return NewCast(node, ty, nil)
+}
+
+// checkValue reports an error if node, whose value is used, has type
+// void. Only calls, dereferences and casts can have type void.
+func checkValue(node Node) {
+	if _, ok := node.Type().(*VoidType); !ok {
+		return
+	}
+	var tok *Token
+	switch n := node.(type) {
+	case *FuncCall:
+		tok = n.tok
+	case *Dereference:
+		tok = n.tok
+	case *Cast:
+		tok = n.tok
+	case *VaStart:
+		tok = n.tok
+	case *VaCopy:
+		tok = n.tok
+	case *VaEnd:
+		tok = n.tok
+	}
+	errorToken(tok, "void value not ignored as it ought to be")
+}
+
+// Assign stores rhs in lhs. A structure or union is copied from the
+// object that rhs designates.
+type Assign struct {
+	lhs AddressGenerator
+	rhs Node
+	ty  Type
+	tok *Token
+}
+
+func NewAssign(lhs AddressGenerator, rhs Node, tok *Token) *Assign {
+	return &Assign{
+		lhs: lhs,
+		rhs: rhs,
+		tok: tok,
+	}
+}
+
+func (a *Assign) AddType() {
+	a.lhs.AddType()
+	a.rhs.AddType()
+	checkValue(a.lhs)
+	checkValue(a.rhs)
+	a.ty = a.lhs.Type()
+	if _, ok := a.ty.(*ArrayType); ok {
+		errorToken(a.tok, "assignment to expression with array type")
+	}
+	if isRecord(a.ty) {
+		if a.rhs.Type() != a.ty {
+			errorToken(a.tok, "incompatible types when assigning to type '%s' from type '%s'", typeString(a.ty), typeString(a.rhs.Type()))
+		}
+		if _, ok := a.rhs.(AddressGenerator); !ok {
+			errorToken(a.tok, "assigning a '%s' from this expression is not supported", typeString(a.ty))
+		}
+		return
+	}
+	a.rhs = convert(a.rhs, a.ty)
+}
+
+func (a *Assign) Type() Type {
+	return a.ty
+}
+
+type Member struct {
+	Node
+	AddressGenerator
+	expr   Node
+	name   string
+	ty     Type
+	offset int
+	tok    *Token
+	// align is the alignment requested by _Alignas for a structure
+	// member, or 0.
+	align int
+}
+
+func NewMember(expr Node, name string, tok *Token) *Member {
+	return &Member{
+		expr: expr,
+		name: name,
+		tok:  tok,
+	}
+}
+
+func (m *Member) AddType() {
+	m.expr.AddType()
+	s, ok := m.expr.Type().(recordType)
+	if !ok {
+		errorToken(m.tok, "not a struct or union")
+	}
+	if isIncomplete(s) {
+		errorToken(m.tok, "invalid use of undefined type '%s'", typeString(s))
+	}
+	mem := s.FindMember(m.name)
+	if mem == nil {
+		errorToken(m.tok, "no member named '%s'", m.name)
+	}
+	m.name = mem.name
+	m.offset = mem.offset
+	m.ty = mem.ty
+	// m.ty = m.expr.Type()
+}
+
+func (m *Member) Type() Type {
+	return m.ty
+}
+
+type Address struct {
+	Unary
+	expr AddressGenerator
+	ty   Type
+}
+
+func NewAddress(expr AddressGenerator) *Address {
+	return &Address{
+		expr: expr,
+	}
+}
+
+func (a *Address) AddType() {
+	a.expr.AddType()
+	if t, ok := a.expr.Type().(*ArrayType); ok {
+		a.ty = NewPointerType(t.base)
+	} else {
+		a.ty = NewPointerType(a.expr.Type())
+	}
+}
+
+func (a *Address) Type() Type {
+	return a.ty
+}
+
+type Dereference struct {
+	AddressGenerator
+	Unary
+	expr Node
+	ty   Type
+	tok  *Token
+}
+
+func NewDereference(expr Node, tok *Token) *Dereference {
+	return &Dereference{
+		expr: expr,
+		tok:  tok,
+	}
+}
+
+func (d *Dereference) AddType() {
+	d.expr.AddType()
+	switch v := d.expr.Type().(type) {
+	case *ArrayType:
+		d.ty = v.base
+	case *PointerType:
+		d.ty = v.base
+	case *FuncType:
+		// A function designator converts to a pointer to the function.
+		d.ty = v
+	default:
+		errorToken(d.tok, "invalid pointer dereference")
+	}
+}
+
+func (d *Dereference) Type() Type {
+	return d.ty
+}
+
+// Return returns the value of expr, if any, converted to ret, the return
+// type of the function.
+type Return struct {
+	Unary
+	expr Node
+	ret  Type
+	tok  *Token
+}
+
+func NewReturn(expr Node, ret Type, tok *Token) *Return {
+	return &Return{
+		expr: expr,
+		ret:  ret,
+		tok:  tok,
+	}
+}
+
+func (r *Return) AddType() {
+	if r.expr == nil {
+		return
+	}
+	r.expr.AddType()
+	if _, ok := r.ret.(*VoidType); ok {
+		// A void function can return the value of a void expression.
+		if _, ok := r.expr.Type().(*VoidType); !ok {
+			errorToken(r.tok, "'return' with a value, in function returning void")
+		}
+		return
+	}
+	checkValue(r.expr)
+	r.expr = convert(r.expr, r.ret)
+}
+
+func (r *Return) Type() Type {
+	return r.ret
+}
+
+type If struct {
+	Node
+	cond Node
+	then Node
+	els  Node
+}
+
+func NewIf(cond Node, then Node, els Node) *If {
+	return &If{
+		cond: cond,
+		then: then,
+		els:  els,
+	}
+}
+
+func (f *If) AddType() {
+	f.cond.AddType()
+	checkValue(f.cond)
+	f.then.AddType()
+	if f.els != nil {
+		f.els.AddType()
+	}
+}
+
+type While struct {
+	Node
+	cond Node
+	then Node
+}
+
+func NewWhile(cond Node, then Node) *While {
+	return &While{
+		cond: cond,
+		then: then,
+	}
+}
+
+func (w *While) AddType() {
+	w.cond.AddType()
+	checkValue(w.cond)
+	w.then.AddType()
+}
+
+type For struct {
+	init  Node
+	cond  Node
+	inc   Node
+	block Node
+}
+
+func NewFor(init Node, cond Node, inc Node, block Node) *For {
+	return &For{
+		init:  init,
+		cond:  cond,
+		inc:   inc,
+		block: block,
+	}
+}
+
+func (f *For) AddType() {
+	if f.init != nil {
+		f.init.AddType()
+	}
+	if f.cond != nil {
+		f.cond.AddType()
+		checkValue(f.cond)
+	}
+	if f.inc != nil {
+		f.inc.AddType()
+	}
+	f.block.AddType()
+}
+
+func (f *For) Type() Type {
+	return nil
+}
+
+type Block struct {
+	body []Node
+}
+
+func NewBlock(block []Node) *Block {
+	return &Block{
+		body: block,
+	}
+}
+
+func (b *Block) AddType() {
+	for i := range b.body {
+		b.body[i].AddType()
+	}
+}
+
+func (b *Block) Type() Type {
+	return nil
+}
+
+// FuncCall calls the function name, declared with the type fty, or nil
+// if it is not declared. An indirect call has no name and calls the
+// function that fn designates or points to instead.
+type FuncCall struct {
+	name string
+	fn   Node
+	args []Node
+	fty  *FuncType
+	ty   Type
+	tok  *Token
+}
+
+func NewFuncCall(name string, args []Node, fty *FuncType, tok *Token) *FuncCall {
+	return &FuncCall{
+		name: name,
+		args: args,
+		fty:  fty,
+		tok:  tok,
+	}
+}
+
+func NewIndirectCall(fn Node, args []Node, tok *Token) *FuncCall {
+	return &FuncCall{
+		fn:   fn,
+		args: args,
+		tok:  tok,
+	}
+}
+
+// AddType converts the arguments to the types of the parameters in the
+// declaration. An undeclared function returns int.
+func (f *FuncCall) AddType() {
+	if f.fn != nil {
+		f.fn.AddType()
+		switch t := f.fn.Type().(type) {
+		case *FuncType:
+			f.fty = t
+		case *PointerType:
+			f.fty, _ = t.base.(*FuncType)
+		}
+		if f.fty == nil {
+			errorToken(f.tok, "called object is not a function or function pointer")
+		}
+	}
+	for i := range f.args {
+		f.args[i].AddType()
+		checkValue(f.args[i])
+		if f.fty != nil && i < len(f.fty.params) {
+			f.args[i] = convert(f.args[i], f.fty.params[i].ty)
+		}
+	}
+	if f.fty != nil {
+		f.ty = f.fty.ret
+	} else {
+		f.ty = intType
+	}
+}
+
+func (f *FuncCall) Type() Type {
+	return f.ty
+}
+
+// checkVaList reports an error at tok, naming the builtin, unless ap is
+// a va_list, which a parameter holds as a pointer to its element.
+func checkVaList(ap Node, tok *Token) {
+	switch t := ap.Type().(type) {
+	case *ArrayType:
+		if t == vaListType {
+			return
+		}
+	case *PointerType:
+		if t.base == pointerBase(vaListType) {
+			return
+		}
+	}
+	errorToken(tok, "argument to '%s' is not of type 'va_list'", tok.str)
+}
+
+// VaStart is __builtin_va_start(ap, last), which sets ap to the first
+// variadic argument of fn.
+type VaStart struct {
+	ap  Node
+	fn  *Function
+	tok *Token
+}
+
+func NewVaStart(ap Node, fn *Function, tok *Token) *VaStart {
+	return &VaStart{
+		ap:  ap,
+		fn:  fn,
+		tok: tok,
+	}
+}
+
+func (v *VaStart) AddType() {
+	v.ap.AddType()
+	checkVaList(v.ap, v.tok)
+}
+
+func (v *VaStart) Type() Type {
+	return voidType
+}
+
+// VaArg is __builtin_va_arg(ap, ty), which reads the next variadic
+// argument from ap as a ty. Only integers and pointers are supported.
+type VaArg struct {
+	ap  Node
+	ty  Type
+	tok *Token
+}
+
+func NewVaArg(ap Node, ty Type, tok *Token) *VaArg {
+	return &VaArg{
+		ap:  ap,
+		ty:  ty,
+		tok: tok,
+	}
+}
+
+func (v *VaArg) AddType() {
+	v.ap.AddType()
+	checkVaList(v.ap, v.tok)
+	if _, ok := v.ty.(*PointerType); !ok && !isInteger(v.ty) {
+		errorToken(v.tok, "'va_arg' of type '%s' is not supported", typeString(v.ty))
+	}
+}
+
+func (v *VaArg) Type() Type {
+	return v.ty
+}
+
+// VaCopy is __builtin_va_copy(dst, src), which copies the state of src
+// to dst.
+type VaCopy struct {
+	dst Node
+	src Node
+	tok *Token
+}
+
+func NewVaCopy(dst Node, src Node, tok *Token) *VaCopy {
+	return &VaCopy{
+		dst: dst,
+		src: src,
+		tok: tok,
+	}
+}
+
+func (v *VaCopy) AddType() {
+	v.dst.AddType()
+	v.src.AddType()
+	checkVaList(v.dst, v.tok)
+	checkVaList(v.src, v.tok)
+}
+
+func (v *VaCopy) Type() Type {
+	return voidType
+}
+
+// VaEnd is __builtin_va_end(ap), which has nothing to clean up.
+type VaEnd struct {
+	ap  Node
+	tok *Token
+}
+
+func NewVaEnd(ap Node, tok *Token) *VaEnd {
+	return &VaEnd{
+		ap:  ap,
+		tok: tok,
+	}
+}
+
+func (v *VaEnd) AddType() {
+	v.ap.AddType()
+	checkVaList(v.ap, v.tok)
+}
+
+func (v *VaEnd) Type() Type {
+	return voidType
+}
+
+type ExpressionStatement struct {
+	statement Node
+}
+
+func NewExpressionStatement(expr Node) *ExpressionStatement {
+	return &ExpressionStatement{
+		statement: expr,
+	}
+}
+
+func (e *ExpressionStatement) AddType() {
+	e.statement.AddType()
+}
+
+func (e *ExpressionStatement) Type() Type {
+	return intType
+}
+
+type Variable struct {
+	// Variable name
+	name string
+	// Type
+	ty Type
+	// Offset from RBP (for local)
+	offset int
+	// Alignment, which _Alignas can make larger than that of ty
+	align int
+
+	isLocal bool
+	isParam bool
+	// Whether the variable is declared extern and defined elsewhere
+	isExtern bool
+	// Whether the global is static and so not visible to the linker
+	isStatic bool
+	// Whether the name is a typedef for ty rather than a variable
+	isTypedef bool
+
+	// (for global)
+	contents string
+	// Addresses stored in contents, in order
+	relocs []reloc
+
+	// Declaration
+	tok *Token
+	// Block nesting depth of the declaration, 0 for globals
+	depth int
+	// Whether the variable is referenced
+	used bool
+}
+
+// reloc is an address in the initial contents of a global, the address
+// of label plus addend, which the linker fills in.
+type reloc struct {
+	offset int
+	label  string
+	addend int
+}
+
+type VarNode struct {
+	Node
+	AddressGenerator
+	variable *Variable
+	ty       Type
+}
+
+func NewVarNode(v *Variable) *VarNode {
+	return &VarNode{
+		variable: v,
+	}
+}
+
+func (v *VarNode) AddType() {
+	v.ty = v.variable.ty
+}
+
+func (v *VarNode) Type() Type {
+	return v.ty
+}
+
+// FuncRef is the name of a function used as a value, which like an
+// array converts to its address.
+type FuncRef struct {
+	name string
+	ty   *FuncType
+}
+
+func NewFuncRef(name string, ty *FuncType) *FuncRef {
+	return &FuncRef{
+		name: name,
+		ty:   ty,
+	}
+}
+
+func (f *FuncRef) AddType() {}
+
+func (f *FuncRef) Type() Type {
+	return f.ty
+}
+
+type Sizeof struct {
+	v   Node
+	tok *Token
+}
+
+func NewSizeof(v Node, tok *Token) *Sizeof {
+	return &Sizeof{
+		v:   v,
+		tok: tok,
+	}
+}
+
+func (s *Sizeof) AddType() {
+	s.v.AddType()
+	if isIncomplete(s.v.Type()) {
+		errorToken(s.tok, "invalid application of 'sizeof' to incomplete type '%s'", typeString(s.v.Type()))
+	}
+}
+
+func (s *Sizeof) Type() Type {
+	return ulongType
+}
+
+type Number struct {
+	Node
+	val int
+	ty  Type
+}
+
+func NewNumber(val int, ty Type) *Number {
+	return &Number{
+		val: val,
+		ty:  ty,
+	}
+}
+
+func (n *Number) AddType() {}
+
+func (n *Number) Type() Type {
+	return n.ty
+}
+
+type Null struct {
+	Node
+}
+
+func NewNull() *Null {
+	return &Null{}
+}
+
+func (n *Null) AddType() {}
+
+// evalConst returns the value of node, a constant expression: an
+// integer, or the address named by label plus val. ok is false if node
+// is not constant.
+func evalConst(node Node) (val int, label string, ok bool) {
+	// An array converts to its address.
+	if _, isArray := node.Type().(*ArrayType); isArray {
+		if a, ok := node.(AddressGenerator); ok {
+			return evalAddress(a)
+		}
+	}
+	switch n := node.(type) {
+	case *Number:
+		return n.val, "", true
+	case *Sizeof:
+		return n.v.Type().size(), "", true
+	case *Cast:
+		val, label, ok = evalConst(n.expr)
+		if label != "" {
+			// Only a pointer or a 64-bit integer holds an address.
+			return val, label, ok && (pointerBase(n.ty) != nil || n.ty.size() == 8)
+		}
+		return truncateConst(val, n.ty), "", ok
+	case *FuncRef:
+		return 0, n.name, true
+	case *Address:
+		return evalAddress(n.expr)
+	case *Not:
+		val, label, ok = evalConst(n.expr)
+		return boolConst(val == 0), "", ok && label == ""
+	case *Add:
+		return evalPointerArith(n.Binary, 1)
+	case *Sub:
+		if pointerBase(n.rhs.Type()) != nil {
+			break
+		}
+		return evalPointerArith(n.Binary, -1)
+	}
+
+	b, isBinary := node.(BinaryNode)
+	if !isBinary {
+		return 0, "", false
+	}
+	l, llabel, lok := evalConst(b.Lhs())
+	r, rlabel, rok := evalConst(b.Rhs())
+	if !lok || !rok || llabel != "" || rlabel != "" {
+		return 0, "", false
+	}
+	var bin *Binary
+	switch n := node.(type) {
+	case *Sub:
+		bin = n.Binary
+		val = l - r
+		if base := pointerBase(n.lhs.Type()); base != nil {
+			// The difference of two pointers counts elements.
+			return (l - r) / base.size(), "", true
+		}
+	case *Mul:
+		bin = n.Binary
+		val = l * r
+	case *Div:
+		bin = n.Binary
+		if r == 0 {
+			return 0, "", false
+		}
+		if isUnsigned(n.operandType()) {
+			val = int(uint64(l) / uint64(r))
+		} else {
+			val = l / r
+		}
+	case *Shl:
+		bin = n.Binary
+		val = l << uint(r)
+	case *Shr:
+		bin = n.Binary
+		if isUnsigned(n.ty) {
+			val = int(uint64(l) >> uint(r))
+		} else {
+			val = l >> uint(r)
+		}
+	case *Equal:
+		return boolConst(l == r), "", true
+	case *NotEqual:
+		return boolConst(l != r), "", true
+	case *LessThan:
+		if isUnsigned(n.operandType()) {
+			return boolConst(uint64(l) < uint64(r)), "", true
+		}
+		return boolConst(l < r), "", true
+	case *LessEqual:
+		if isUnsigned(n.operandType()) {
+			return boolConst(uint64(l) <= uint64(r)), "", true
+		}
+		return boolConst(l <= r), "", true
+	case *LogAnd:
+		return boolConst(l != 0 && r != 0), "", true
+	case *LogOr:
+		return boolConst(l != 0 || r != 0), "", true
+	default:
+		return 0, "", false
+	}
+	return truncateConst(val, bin.ty), "", true
+}
+
+// evalPointerArith evaluates b, an addition, or a subtraction if sign
+// is -1, where the left operand may be an address.
+func evalPointerArith(b *Binary, sign int) (int, string, bool) {
+	l, label, lok := evalConst(b.lhs)
+	r, rlabel, rok := evalConst(b.rhs)
+	if !lok || !rok || rlabel != "" {
+		return 0, "", false
+	}
+	if base := pointerBase(b.ty); base != nil {
+		return l + sign*r*base.size(), label, true
+	}
+	if label != "" {
+		return 0, "", false
+	}
+	return truncateConst(l+sign*r, b.ty), "", true
+}
+
+// evalAddress returns the address of the object that node designates if
+// it is constant, the address named by label plus val.
+func evalAddress(node AddressGenerator) (val int, label string, ok bool) {
+	switch n := node.(type) {
+	case *VarNode:
+		if n.variable.isLocal {
+			return 0, "", false
+		}
+		return 0, n.variable.name, true
+	case *FuncRef:
+		return 0, n.name, true
+	case *Member:
+		val, label, ok = evalAddress(n.expr.(AddressGenerator))
+		return val + n.offset, label, ok
+	case *Dereference:
+		return evalConst(n.expr)
+	}
+	return 0, "", false
+}
+
+// truncateConst converts val to the integer type ty, like a cast.
+func truncateConst(val int, ty Type) int {
+	switch {
+	case ty == boolType:
+		return boolConst(val != 0)
+	case !isInteger(ty) || is64(ty):
+		return val
+	case ty.size() == 1 && isUnsigned(ty):
+		return int(uint8(val))
+	case ty.size() == 1:
+		return int(int8(val))
+	case ty.size() == 2 && isUnsigned(ty):
+		return int(uint16(val))
+	case ty.size() == 2:
+		return int(int16(val))
+	case isUnsigned(ty):
+		return int(uint32(val))
+	}
+	return int(int32(val))
+}
+
+func boolConst(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
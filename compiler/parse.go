@@ -0,0 +1,1416 @@
+package compiler
+
+import (
+	"fmt"
+)
+
+type Function struct {
+	name   string
+	ty     *FuncType
+	params []*Variable
+	// Whether the function is static and so not visible to the linker
+	isStatic bool
+
+	node      []Node
+	locals    []*Variable
+	stackSize int
+	// For a variadic function, where the prologue saves the argument
+	// registers for va_arg
+	vaArea *Variable
+	// The largest alignment of a local, if more than the 16 bytes that
+	// rbp has on entry, so the frame must be realigned
+	frameAlign int
+}
+
+// pushVar declares a variable named by tok, or by name if tok is nil.
+func (p *Parser) pushVar(tok *Token, name string, ty Type, isLocal bool) *Variable {
+	v := &Variable{
+		name:    name,
+		ty:      ty,
+		align:   ty.align(),
+		isLocal: isLocal,
+		tok:     tok,
+		depth:   p.depth,
+	}
+	if isLocal {
+		p.warnShadow(v)
+		p.locals = append([]*Variable{v}, p.locals...)
+	} else {
+		p.globals = append([]*Variable{v}, p.globals...)
+	}
+
+	p.scope = append([]*Variable{v}, p.scope...)
+	return v
+}
+
+// pushTypedef declares name as a typedef for ty in the current scope.
+func (p *Parser) pushTypedef(tok *Token, name string, ty Type) {
+	v := &Variable{
+		name:      name,
+		ty:        ty,
+		tok:       tok,
+		depth:     p.depth,
+		isTypedef: true,
+	}
+	p.scope = append([]*Variable{v}, p.scope...)
+}
+
+// findTypedef returns the type named by tok if it is a typedef name.
+func (p *Parser) findTypedef(tok *Token) Type {
+	if tok.kind != TK_IDENT {
+		return nil
+	}
+	if v := p.findVariable(tok); v != nil && v.isTypedef {
+		return v.ty
+	}
+	return nil
+}
+
+// TagScope is a structure or union tag in scope. Tags have their own
+// namespace, shared by structures and unions.
+type TagScope struct {
+	name  string
+	ty    recordType
+	depth int
+}
+
+// findTag returns the structure or union named by tok in the innermost
+// scope, or nil if there is none.
+func (p *Parser) findTag(tok *Token) *TagScope {
+	for _, t := range p.tags {
+		if t.name == tok.str {
+			return t
+		}
+	}
+	return nil
+}
+
+// pushTag declares the tag tok for ty in the current scope.
+func (p *Parser) pushTag(tok *Token, ty recordType) {
+	p.tags = append([]*TagScope{{name: tok.str, ty: ty, depth: p.depth}}, p.tags...)
+}
+
+func (p *Parser) newLabel() string {
+	label := fmt.Sprintf(".L.data.%d", p.labelCount)
+	p.labelCount++
+	return label
+}
+
+type Parser struct {
+	token   *Token
+	locals  []*Variable
+	globals []*Variable
+	scope   []*Variable
+	tags    []*TagScope
+	diag    *diagnostics
+	// depth is the block nesting depth, 0 at file scope.
+	depth int
+	// fn is the function being parsed, and funcName its __func__ once
+	// it is used.
+	fn       *Function
+	funcName *Variable
+	// asmLabels maps the functions declared with an assembler label to
+	// the symbol they are called by.
+	asmLabels map[string]string
+	// funcTypes maps the declared functions to their types. A call to
+	// an undeclared function returns int.
+	funcTypes map[string]*FuncType
+
+	labelCount int
+}
+
+func NewParser(token *Token, diag *diagnostics) *Parser {
+	return &Parser{
+		token:     token,
+		diag:      diag,
+		asmLabels: map[string]string{},
+		funcTypes: map[string]*FuncType{},
+	}
+}
+
+// synchronize skips the declaration or statement beginning at start
+// that has an error, up to and including the next ';' or the '}'
+// closing a block opened in it, and a ';' after that '}', which ends a
+// declaration with an initializer list. It stops before a '}' that
+// closes an enclosing block. Starting from the beginning rather than the
+// error keeps the braces balanced when the error is inside them.
+func (p *Parser) synchronize(start *Token) {
+	p.token = start
+	depth := 0
+	for !p.token.AtEOF() {
+		switch {
+		case p.consume("{"):
+			depth++
+		case p.peek("}"):
+			if depth == 0 {
+				return
+			}
+			p.token = p.token.next
+			depth--
+			if depth == 0 {
+				p.consume(";")
+				return
+			}
+		case p.consume(";"):
+			if depth == 0 {
+				return
+			}
+		default:
+			p.token = p.token.next
+		}
+	}
+}
+
+// expectBlockEnd reports whether the '}' closing a block is next,
+// consuming it. It is an error to reach the end of the file instead.
+func (p *Parser) expectBlockEnd() bool {
+	if p.token.AtEOF() {
+		errorToken(p.token, "expected '}'")
+	}
+	return p.consume("}")
+}
+
+func (p *Parser) Program() *Program {
+	funcs := []*Function{}
+
+	for !p.token.AtEOF() {
+		start := p.token
+		ok := p.diag.try(func() {
+			attr := &declAttr{}
+			base := p.declspec(attr)
+			if p.consume(";") {
+				return
+			}
+			ty, name := p.declarator(base)
+			if fty, ok := ty.(*FuncType); ok && name != nil && p.peek("{") {
+				funcs = append(funcs, p.function(fty, name, attr))
+				return
+			}
+			p.globalDeclaration(base, attr, ty, name)
+		})
+		if !ok {
+			p.synchronize(start)
+			p.consume("}")
+		}
+	}
+	prog := &Program{
+		globals: p.globals,
+		funcs:   funcs,
+	}
+	return prog
+}
+
+// declAttr holds the storage class and function specifiers of a
+// declaration.
+type declAttr struct {
+	isTypedef bool
+	isExtern  bool
+	isStatic  bool
+	// isMember is set for a structure member, which can have an
+	// alignment but no storage class.
+	isMember bool
+	// align is the alignment requested by _Alignas, or 0.
+	align int
+}
+
+// Each type specifier adds its own value to a counter, so that every
+// valid combination of them, such as "unsigned long int", has a distinct
+// sum.
+const (
+	specVoid     = 1 << 0
+	specChar     = 1 << 2
+	specShort    = 1 << 4
+	specInt      = 1 << 6
+	specLong     = 1 << 8
+	specBool     = 1 << 10
+	specOther    = 1 << 12
+	specSigned   = 1 << 13
+	specUnsigned = 1 << 14
+)
+
+// declKeywords are the keywords that can start a declaration.
+var declKeywords = map[string]bool{
+	"void": true, "_Bool": true, "char": true, "short": true, "int": true,
+	"long": true, "signed": true, "unsigned": true, "float": true,
+	"double": true, "struct": true, "union": true, "__builtin_va_list": true,
+	"const": true, "volatile": true, "restrict": true,
+	"typedef": true, "extern": true, "static": true, "auto": true,
+	"register": true, "inline": true, "_Noreturn": true,
+	"__attribute__": true, "__extension__": true, "_Alignas": true,
+}
+
+// declspec reads the declaration specifiers of a declaration and returns
+// the type they specify. The storage class is stored in attr, which is
+// nil where no storage class is allowed.
+func (p *Parser) declspec(attr *declAttr) Type {
+	var ty Type
+	counter := 0
+	for {
+		tok := p.token
+		if counter == 0 && p.findTypedef(tok) != nil {
+			ty = p.findTypedef(tok)
+			p.token = tok.next
+			counter += specOther
+			continue
+		}
+		if tok.kind != TK_RESERVED || !declKeywords[tok.str] {
+			break
+		}
+
+		switch tok.str {
+		case "typedef", "extern", "static", "auto", "register":
+			if attr == nil || attr.isMember {
+				errorToken(tok, "storage class specified for a parameter or member")
+			}
+			attr.isTypedef = attr.isTypedef || tok.str == "typedef"
+			attr.isExtern = attr.isExtern || tok.str == "extern"
+			attr.isStatic = attr.isStatic || tok.str == "static"
+			p.token = tok.next
+			continue
+		case "inline", "_Noreturn", "const", "volatile", "restrict", "__extension__":
+			// These do not affect the generated code.
+			p.token = tok.next
+			continue
+		case "__attribute__":
+			p.attributes()
+			continue
+		case "_Alignas":
+			if attr == nil {
+				errorToken(tok, "_Alignas specified for a parameter")
+			}
+			if align := p.alignas(); align > attr.align {
+				attr.align = align
+			}
+			continue
+		case "float", "double":
+			errorToken(tok, "floating types are not supported")
+		}
+
+		switch tok.str {
+		case "struct", "union":
+			if counter != 0 {
+				errorToken(tok, "two or more data types in declaration specifiers")
+			}
+			ty = p.structDecl()
+			counter += specOther
+			continue
+		case "__builtin_va_list":
+			ty = vaListType
+			counter += specOther
+		case "void":
+			counter += specVoid
+		case "_Bool":
+			counter += specBool
+		case "char":
+			counter += specChar
+		case "short":
+			counter += specShort
+		case "int":
+			counter += specInt
+		case "long":
+			counter += specLong
+		case "signed":
+			counter |= specSigned
+		case "unsigned":
+			counter |= specUnsigned
+		}
+		p.token = tok.next
+
+		switch counter {
+		case specOther:
+		case specVoid:
+			ty = voidType
+		case specBool:
+			ty = boolType
+		case specChar, specSigned + specChar:
+			ty = charType
+		case specUnsigned + specChar:
+			ty = ucharType
+		case specShort, specShort + specInt,
+			specSigned + specShort, specSigned + specShort + specInt:
+			ty = shortType
+		case specUnsigned + specShort, specUnsigned + specShort + specInt:
+			ty = ushortType
+		case specInt, specSigned, specSigned + specInt:
+			ty = intType
+		case specUnsigned, specUnsigned + specInt:
+			ty = uintType
+		case specLong, specLong + specInt,
+			specSigned + specLong, specSigned + specLong + specInt,
+			specLong + specLong, specLong + specLong + specInt,
+			specSigned + specLong + specLong, specSigned + specLong + specLong + specInt:
+			ty = longType
+		case specUnsigned + specLong, specUnsigned + specLong + specInt,
+			specUnsigned + specLong + specLong, specUnsigned + specLong + specLong + specInt:
+			ty = ulongType
+		default:
+			errorToken(tok, "two or more data types in declaration specifiers")
+		}
+	}
+
+	if counter == 0 {
+		errorToken(p.token, "expected declaration specifiers")
+	}
+	return ty
+}
+
+// alignas reads an alignment specifier, _Alignas with a type name or an
+// integer constant, and returns the alignment it requests.
+func (p *Parser) alignas() int {
+	p.expect("_Alignas")
+	p.expect("(")
+	var align int
+	if p.isTypeName(p.token) {
+		align = p.typeName().align()
+	} else {
+		tok := p.token
+		align = p.expectNumber()
+		if align <= 0 || align&(align-1) != 0 {
+			errorToken(tok, "requested alignment is not a positive power of 2")
+		}
+	}
+	p.expect(")")
+	return align
+}
+
+// attributes skips GNU attribute specifiers such as
+//
+//	__attribute__((noreturn, format(printf, 1, 2)))
+//
+// which are accepted and ignored.
+func (p *Parser) attributes() {
+	for p.consume("__attribute__") {
+		p.expect("(")
+		for depth := 1; depth > 0; {
+			switch {
+			case p.token.AtEOF():
+				errorToken(p.token, "expected ')'")
+			case p.consume("("):
+				depth++
+			case p.consume(")"):
+				depth--
+			default:
+				p.token = p.token.next
+			}
+		}
+	}
+}
+
+// asmLabel reads an optional GNU assembler label, as in
+//
+//	int scanf(const char *format, ...) __asm__("__isoc99_scanf");
+//
+// and returns the name it gives the declared symbol, or "" if there is
+// none.
+func (p *Parser) asmLabel() string {
+	if !p.consume("__asm__") {
+		return ""
+	}
+	p.expect("(")
+	tok := p.token
+	if tok.kind != TK_STRING {
+		errorToken(tok, "expected a string literal")
+	}
+	p.token = tok.next
+	p.expect(")")
+	return tok.contents
+}
+
+// consumeQualifiers skips type qualifiers and attributes, which have no
+// effect on the generated code.
+func (p *Parser) consumeQualifiers() {
+	for {
+		p.attributes()
+		if !p.consume("const") && !p.consume("volatile") && !p.consume("restrict") {
+			return
+		}
+	}
+}
+
+// declarator reads a declarator for the base type ty and returns the
+// declared type and name. The name is nil for an abstract declarator,
+// which is allowed in any context and reported by the caller.
+//
+//	declarator = ("*" qualifiers)* ("(" declarator ")" | ident?) typeSuffix
+func (p *Parser) declarator(ty Type) (Type, *Token) {
+	for p.consume("*") {
+		ty = NewPointerType(ty)
+		p.consumeQualifiers()
+	}
+
+	if p.isNestedDeclarator() {
+		// The suffix after the parentheses applies first, as in the
+		// pointer to function "int (*fp)(int)".
+		start := p.token
+		p.expect("(")
+		p.declarator(ty)
+		p.expect(")")
+		ty = p.typeSuffix(ty)
+		end := p.token
+		p.token = start.next
+		ty, name := p.declarator(ty)
+		p.token = end
+		return ty, name
+	}
+
+	var name *Token
+	if p.token.kind == TK_IDENT {
+		name = p.token
+		p.token = p.token.next
+	}
+	return p.typeSuffix(ty), name
+}
+
+// isNestedDeclarator reports whether the next '(' encloses a declarator
+// rather than starting the parameters of a function.
+func (p *Parser) isNestedDeclarator() bool {
+	if !p.peek("(") {
+		return false
+	}
+	next := p.token.next
+	switch {
+	case next.kind == TK_RESERVED:
+		return next.str == "*" || next.str == "(" || next.str == "__attribute__"
+	case next.kind == TK_IDENT:
+		return p.findTypedef(next) == nil
+	}
+	return false
+}
+
+// typeSuffix = "(" funcParams | "[" num "]" typeSuffix | ε
+func (p *Parser) typeSuffix(ty Type) Type {
+	if p.consume("(") {
+		return p.funcParams(ty)
+	}
+	if !p.consume("[") {
+		return ty
+	}
+	tok := p.token
+	size := p.expectNumber()
+	p.expect("]")
+	ty = p.typeSuffix(ty)
+	if isIncomplete(ty) {
+		errorToken(tok, "array type has incomplete element type '%s'", typeString(ty))
+	}
+	return NewArrayType(ty, size)
+}
+
+// funcParams reads the parameters of a function returning ret up to the
+// closing parenthesis and returns the function type.
+func (p *Parser) funcParams(ret Type) Type {
+	if p.peek("void") && p.token.next.str == ")" {
+		p.token = p.token.next.next
+		return NewFuncType(ret, nil, false)
+	}
+
+	params := []*Param{}
+	for !p.consume(")") {
+		if len(params) > 0 {
+			p.expect(",")
+			if p.consume("...") {
+				p.expect(")")
+				return NewFuncType(ret, params, true)
+			}
+		}
+		ty := p.declspec(nil)
+		ty, name := p.declarator(ty)
+		p.attributes()
+		// Parameters of array and function type are pointers.
+		switch t := ty.(type) {
+		case *ArrayType:
+			ty = NewPointerType(t.base)
+		case *FuncType:
+			ty = NewPointerType(t)
+		}
+		params = append(params, &Param{name: name, ty: ty})
+	}
+	return NewFuncType(ret, params, false)
+}
+
+// structDecl reads a structure or union specifier. A tag without a body
+// refers to the type declared with that tag, or declares an incomplete
+// one if there is none. "struct tag;" always declares the tag in the
+// current scope, and a body completes the type of that tag declared in
+// the same scope, so that it can point to itself.
+func (p *Parser) structDecl() Type {
+	kind := p.token.str
+	p.token = p.token.next
+	p.attributes()
+	tag := p.consumeIdent()
+	if tag != nil && !p.peek("{") {
+		if t := p.findTag(tag); t != nil && (!p.peek(";") || t.depth == p.depth) {
+			p.checkTagKind(tag, kind, t.ty)
+			return t.ty
+		}
+		ty := newIncompleteRecord(kind, tag)
+		p.pushTag(tag, ty)
+		return ty
+	}
+	p.expect("{")
+
+	var ty recordType
+	if tag != nil {
+		if t := p.findTag(tag); t != nil && t.depth == p.depth {
+			p.checkTagKind(tag, kind, t.ty)
+			if !isIncomplete(t.ty) {
+				errorToken(tag, "redefinition of '%s %s'", kind, tag.str)
+			}
+			ty = t.ty
+		} else {
+			ty = newIncompleteRecord(kind, tag)
+			p.pushTag(tag, ty)
+		}
+	}
+
+	members := []*Member{}
+	for !p.expectBlockEnd() {
+		members = append(members, p.structMembers()...)
+	}
+
+	if ty == nil {
+		ty = newIncompleteRecord(kind, nil)
+	}
+	ty.setMembers(members)
+	return ty
+}
+
+// newIncompleteRecord returns an incomplete structure or union, as kind
+// says, named tag.
+func newIncompleteRecord(kind string, tag *Token) recordType {
+	if kind == "union" {
+		return newIncompleteUnion(tag)
+	}
+	return newIncompleteStruct(tag)
+}
+
+// checkTagKind reports an error if tag, used with the keyword kind,
+// names ty of the other kind.
+func (p *Parser) checkTagKind(tag *Token, kind string, ty recordType) {
+	if _, isUnion := ty.(*UnionType); isUnion != (kind == "union") {
+		errorToken(tag, "'%s' defined as wrong kind of tag", tag.str)
+	}
+}
+
+// structMembers reads a member declaration, which may declare several
+// members.
+func (p *Parser) structMembers() []*Member {
+	attr := &declAttr{isMember: true}
+	base := p.declspec(attr)
+	members := []*Member{}
+	for len(members) == 0 || p.consume(",") {
+		ty, name := p.declarator(base)
+		p.attributes()
+		if name == nil {
+			errorToken(p.token, "expected an identifier")
+		}
+		if isIncomplete(ty) {
+			errorToken(name, "field '%s' has incomplete type", name.str)
+		}
+		p.checkObjectType(name, ty)
+		members = append(members, &Member{
+			ty:    ty,
+			name:  name.str,
+			align: attr.align,
+		})
+	}
+	p.expect(";")
+	return members
+}
+
+// typeName reads a type name, a declaration of no identifier, such as
+// "char *" in a cast.
+func (p *Parser) typeName() Type {
+	ty, name := p.declarator(p.declspec(nil))
+	if name != nil {
+		errorToken(name, "expected ')' before '%s'", name.str)
+	}
+	return ty
+}
+
+// checkObjectType reports an error if an object named name cannot have
+// the type ty.
+func (p *Parser) checkObjectType(name *Token, ty Type) {
+	if _, ok := ty.(*VoidType); ok {
+		errorToken(name, "variable or field '%s' declared void", name.str)
+	}
+}
+
+// function reads the body of the function name of type ty.
+func (p *Parser) function(ty *FuncType, name *Token, attr *declAttr) *Function {
+	p.locals = []*Variable{}
+	sc, tags := p.scope, p.tags
+	p.depth++
+	defer func() {
+		p.scope, p.tags = sc, tags
+		p.depth--
+	}()
+
+	fn := &Function{name: name.str, ty: ty, isStatic: attr.isStatic}
+	p.funcTypes[name.str] = ty
+	p.fn, p.funcName = fn, nil
+	defer func() { p.fn = nil }()
+	for _, param := range ty.params {
+		if param.name == nil {
+			errorToken(name, "parameter name omitted")
+		}
+		v := p.pushVar(param.name, param.name.str, param.ty, true)
+		v.isParam = true
+		fn.params = append(fn.params, v)
+	}
+	if ty.variadic {
+		// The six general purpose registers, then the eight 16-byte
+		// vector registers, not visible by name
+		ty := NewArrayType(charType, 176)
+		fn.vaArea = &Variable{name: "__va_area__", ty: ty, align: 16, isLocal: true, used: true}
+		p.locals = append(p.locals, fn.vaArea)
+	}
+	p.expect("{")
+
+	nerrors := p.diag.nerrors
+	l, end := p.stmtList()
+
+	fn.node = l
+	fn.locals = p.locals
+
+	p.warnUnused(fn)
+	// Falling off the end of main returns 0. A statement with an error
+	// may have been a return.
+	_, isVoid := ty.ret.(*VoidType)
+	if fn.name != "main" && !isVoid && p.diag.nerrors == nerrors && !alwaysReturns(NewBlock(l)) {
+		p.diag.warn(end, WarnReturnType, "control reaches end of non-void function")
+	}
+	return fn
+}
+
+// stmtList parses statements up to and including the '}' closing the
+// current block, which it returns along with the statements.
+func (p *Parser) stmtList() ([]Node, *Token) {
+	l := []Node{}
+	returned := false
+	warned := false
+	for {
+		tok := p.token
+		if p.expectBlockEnd() {
+			return l, tok
+		}
+		node := p.stmt()
+		if _, ok := node.(*Null); returned && !ok && !warned {
+			p.diag.warn(tok, WarnUnreachableCode, "statement is unreachable")
+			warned = true
+		}
+		returned = returned || alwaysReturns(node)
+		l = append(l, node)
+	}
+}
+
+// alwaysReturns reports whether control never flows past node because
+// every path through it reaches a return statement or loops forever.
+func alwaysReturns(node Node) bool {
+	switch n := node.(type) {
+	case *Return:
+		return true
+	case *Block:
+		for _, stmt := range n.body {
+			if alwaysReturns(stmt) {
+				return true
+			}
+		}
+	case *If:
+		return n.els != nil && alwaysReturns(n.then) && alwaysReturns(n.els)
+	case *While:
+		num, ok := n.cond.(*Number)
+		return ok && num.val != 0
+	case *For:
+		return n.cond == nil
+	}
+	return false
+}
+
+// warnShadow warns if the local variable v hides a variable declared in
+// an enclosing scope.
+func (p *Parser) warnShadow(v *Variable) {
+	prev := p.findVariable(v.tok)
+	if prev == nil || prev.depth >= v.depth {
+		return
+	}
+
+	var d *Diagnostic
+	switch {
+	case prev.isParam:
+		d = p.diag.warn(v.tok, WarnShadow, "declaration of '%s' shadows a parameter", v.name)
+	case !prev.isLocal:
+		d = p.diag.warn(v.tok, WarnShadow, "declaration of '%s' shadows a global declaration", v.name)
+	default:
+		d = p.diag.warn(v.tok, WarnShadow, "declaration of '%s' shadows a previous local", v.name)
+	}
+	if d != nil {
+		d.Notes = append(d.Notes, newNote(prev.tok, "shadowed declaration is here"))
+	}
+}
+
+// warnUnused warns about the parameters and local variables of fn that
+// are never referenced.
+func (p *Parser) warnUnused(fn *Function) {
+	for i := len(fn.locals) - 1; i >= 0; i-- {
+		v := fn.locals[i]
+		switch {
+		case v.used:
+		case v.isParam:
+			p.diag.warn(v.tok, WarnUnusedParameter, "unused parameter '%s'", v.name)
+		default:
+			p.diag.warn(v.tok, WarnUnusedVariable, "unused variable '%s'", v.name)
+		}
+	}
+}
+
+// AddType assigns types to the statements of f. An error in a statement
+// is recorded in diag and checking continues with the next statement.
+func (f *Function) AddType(diag *diagnostics) {
+	for i := range f.node {
+		diag.try(f.node[i].AddType)
+	}
+}
+
+// globalDeclaration reads the rest of a file-scope declaration with the
+// specifiers base and attr, whose first declarator, of type ty naming
+// name, has been read.
+func (p *Parser) globalDeclaration(base Type, attr *declAttr, ty Type, name *Token) {
+	for {
+		label := p.asmLabel()
+		p.attributes()
+		if name == nil {
+			errorToken(p.token, "expected an identifier")
+		}
+		_, isFunc := ty.(*FuncType)
+		switch {
+		case attr.isTypedef:
+			p.pushTypedef(name, name.str, ty)
+		case isFunc:
+			p.funcTypes[name.str] = ty.(*FuncType)
+			if label != "" {
+				p.asmLabels[name.str] = label
+			}
+		case label != "":
+			errorToken(name, "assembler labels are only supported on functions")
+		default:
+			if isIncomplete(ty) && !attr.isExtern {
+				errorToken(name, "storage size of '%s' isn't known", name.str)
+			}
+			p.checkObjectType(name, ty)
+			v := p.pushVar(name, name.str, ty, false)
+			v.isExtern = attr.isExtern
+			v.isStatic = attr.isStatic
+			if attr.align > v.align {
+				v.align = attr.align
+			}
+			if p.consume("=") {
+				v.isExtern = false
+				p.globalInitializer(v)
+			}
+		}
+		if !p.consume(",") {
+			break
+		}
+		ty, name = p.declarator(base)
+	}
+	p.expect(";")
+}
+
+// declaration reads a declaration in a block and returns the
+// assignments of the initializers.
+func (p *Parser) declaration() Node {
+	attr := &declAttr{}
+	base := p.declspec(attr)
+	nodes := []Node{}
+	for i := 0; !p.consume(";"); i++ {
+		if i > 0 {
+			p.expect(",")
+		}
+		ty, name := p.declarator(base)
+		label := p.asmLabel()
+		p.attributes()
+		if name == nil {
+			errorToken(p.token, "expected an identifier")
+		}
+		if attr.isTypedef {
+			p.pushTypedef(name, name.str, ty)
+			continue
+		}
+		if fty, ok := ty.(*FuncType); ok {
+			p.funcTypes[name.str] = fty
+			if label != "" {
+				p.asmLabels[name.str] = label
+			}
+			continue
+		}
+		if label != "" {
+			errorToken(name, "assembler labels are only supported on functions")
+		}
+		if attr.isStatic || attr.isExtern {
+			errorToken(name, "static and extern local variables are not supported")
+		}
+		if isIncomplete(ty) {
+			errorToken(name, "storage size of '%s' isn't known", name.str)
+		}
+		p.checkObjectType(name, ty)
+		v := p.pushVar(name, name.str, ty, true)
+		if attr.align > v.align {
+			v.align = attr.align
+		}
+		if !p.consume("=") {
+			continue
+		}
+		for _, node := range p.initializer(NewVarNode(v), ty, name) {
+			nodes = append(nodes, NewExpressionStatement(node))
+		}
+	}
+
+	switch len(nodes) {
+	case 0:
+		return NewNull()
+	case 1:
+		return nodes[0]
+	}
+	return NewBlock(nodes)
+}
+
+// initializer reads the initializer of the object of type ty that node
+// designates and returns the assignments that initialize it. tok is the
+// name of the variable being declared.
+func (p *Parser) initializer(node AddressGenerator, ty Type, tok *Token) []Node {
+	if p.consume("{") {
+		nodes := p.initList(node, ty, tok)
+		p.expect("}")
+		return nodes
+	}
+	if _, ok := ty.(*ArrayType); ok || isRecord(ty) {
+		errorToken(p.token, "invalid initializer")
+	}
+	return []Node{NewAssign(node, p.assign(), tok)}
+}
+
+// globalInitializer reads the initializer of the global v, which is
+// stored in the data section and so must be made of constants.
+func (p *Parser) globalInitializer(v *Variable) {
+	buf := make([]byte, v.ty.size())
+	for _, node := range p.initializer(NewVarNode(v), v.ty, v.tok) {
+		a := node.(*Assign)
+		a.AddType()
+		offset, _, _ := evalAddress(a.lhs)
+		val, label, ok := evalConst(a.rhs)
+		if !ok {
+			errorToken(a.tok, "initializer element is not constant")
+		}
+		if label != "" {
+			v.relocs = append(v.relocs, reloc{offset, label, val})
+			continue
+		}
+		for i := 0; i < a.ty.size(); i++ {
+			buf[offset+i] = byte(val >> (8 * i))
+		}
+	}
+	v.contents = string(buf)
+}
+
+// initList reads the initializers in braces, which initialize the
+// elements of an array, the members of a structure or the first member of
+// a union in order. The ones that the list leaves out are set to 0. A
+// scalar can also have its initializer in braces.
+func (p *Parser) initList(node AddressGenerator, ty Type, tok *Token) []Node {
+	type element struct {
+		node AddressGenerator
+		ty   Type
+	}
+	elems := []element{}
+	switch t := ty.(type) {
+	case *ArrayType:
+		for i := 0; i < t.len; i++ {
+			elem := NewDereference(NewAdd(node, NewNumber(i, intType)), tok)
+			elems = append(elems, element{elem, t.base})
+		}
+	case *Struct:
+		for _, m := range t.members {
+			elems = append(elems, element{NewMember(node, m.name, tok), m.ty})
+		}
+	case *UnionType:
+		if len(t.members) > 0 {
+			m := t.members[0]
+			elems = append(elems, element{NewMember(node, m.name, tok), m.ty})
+		}
+	default:
+		elems = append(elems, element{node, ty})
+	}
+
+	nodes := []Node{}
+	i := 0
+	for i < len(elems) && !p.peek("}") {
+		nodes = append(nodes, p.initializer(elems[i].node, elems[i].ty, tok)...)
+		i++
+		if !p.consume(",") {
+			break
+		}
+	}
+	if !p.peek("}") {
+		errorToken(p.token, "excess elements in initializer")
+	}
+	for ; i < len(elems); i++ {
+		nodes = append(nodes, zeroInitializer(elems[i].node, elems[i].ty, tok)...)
+	}
+	return nodes
+}
+
+// zeroInitializer returns the assignments that set the object of type ty
+// that node designates to 0.
+func zeroInitializer(node AddressGenerator, ty Type, tok *Token) []Node {
+	switch t := ty.(type) {
+	case *ArrayType:
+		nodes := []Node{}
+		for i := 0; i < t.len; i++ {
+			elem := NewDereference(NewAdd(node, NewNumber(i, intType)), tok)
+			nodes = append(nodes, zeroInitializer(elem, t.base, tok)...)
+		}
+		return nodes
+	case *Struct:
+		nodes := []Node{}
+		for _, m := range t.members {
+			nodes = append(nodes, zeroInitializer(NewMember(node, m.name, tok), m.ty, tok)...)
+		}
+		return nodes
+	case *UnionType:
+		if len(t.members) == 0 {
+			return nil
+		}
+		m := t.members[0]
+		return zeroInitializer(NewMember(node, m.name, tok), m.ty, tok)
+	}
+	return []Node{NewAssign(node, NewNumber(0, intType), tok)}
+}
+
+func (p *Parser) readExprStmt() Node {
+	return NewExpressionStatement(p.expr())
+}
+
+// isTypeName reports whether a declaration or a type name starts at tok.
+func (p *Parser) isTypeName(tok *Token) bool {
+	// __extension__ may also precede an expression.
+	for tok.kind == TK_RESERVED && tok.str == "__extension__" {
+		tok = tok.next
+	}
+	if tok.kind == TK_RESERVED {
+		return declKeywords[tok.str]
+	}
+	return p.findTypedef(tok) != nil
+}
+
+func (p *Parser) stmt() Node {
+	var node Node
+	start := p.token
+	if !p.diag.try(func() { node = p.stmt2() }) {
+		p.synchronize(start)
+		return NewNull()
+	}
+	return node
+}
+
+func (p *Parser) stmt2() Node {
+	if tok := p.token; p.consume("return") {
+		if p.consume(";") {
+			if _, ok := p.fn.ty.ret.(*VoidType); !ok {
+				errorToken(tok, "'return' with no value, in function returning non-void")
+			}
+			return NewReturn(nil, p.fn.ty.ret, tok)
+		}
+		node := NewReturn(p.expr(), p.fn.ty.ret, tok)
+		p.expect(";")
+		return node
+	}
+
+	if p.consume("if") {
+		var cond Node
+		var then Node
+		var els Node
+		p.expect("(")
+		cond = p.expr()
+		p.expect(")")
+		then = p.stmt()
+		if p.consume("else") {
+			els = p.stmt()
+		}
+		return NewIf(cond, then, els)
+	}
+
+	if p.consume("while") {
+		p.expect("(")
+		cond := p.expr()
+		p.expect(")")
+		then := p.stmt()
+		return NewWhile(cond, then)
+	}
+
+	if p.consume("for") {
+		var init Node
+		var cond Node
+		var inc Node
+		var block Node
+		p.expect("(")
+		if !p.consume(";") {
+			init = p.readExprStmt()
+			p.expect(";")
+		}
+		if !p.consume(";") {
+			cond = p.expr()
+			p.expect(";")
+		}
+		if !p.consume(")") {
+			inc = p.readExprStmt()
+			p.expect(")")
+		}
+		block = p.stmt()
+		return NewFor(init, cond, inc, block)
+	}
+
+	if p.consume("{") {
+		sc, tags := p.scope, p.tags
+		p.depth++
+		l, _ := p.stmtList()
+		p.depth--
+		p.scope, p.tags = sc, tags
+
+		node := NewBlock(l)
+
+		return node
+	}
+
+	if p.isTypeName(p.token) {
+		return p.declaration()
+	}
+
+	node := p.readExprStmt()
+	p.expect(";")
+	return node
+}
+
+func (p *Parser) expr() Node {
+	return p.assign()
+}
+
+// lvalue returns node as an AddressGenerator, or reports an error at
+// tok if node does not designate an object.
+func (p *Parser) lvalue(node Node, tok *Token) AddressGenerator {
+	lhs, ok := node.(AddressGenerator)
+	if !ok {
+		errorToken(tok, "not an lvalue")
+	}
+	return lhs
+}
+
+func (p *Parser) assign() Node {
+	tok := p.token
+	node := p.logOr()
+	if eq := p.token; p.consume("=") {
+		node = NewAssign(p.lvalue(node, tok), p.assign(), eq)
+	}
+
+	return node
+}
+
+func (p *Parser) logOr() Node {
+	node := p.logAnd()
+	for p.consume("||") {
+		node = NewLogOr(node, p.logAnd())
+	}
+	return node
+}
+
+func (p *Parser) logAnd() Node {
+	node := p.equality()
+	for p.consume("&&") {
+		node = NewLogAnd(node, p.equality())
+	}
+	return node
+}
+
+func (p *Parser) equality() Node {
+	node := p.relational()
+
+	for {
+		if p.consume("==") {
+			node = NewEqual(node, p.relational())
+		} else if p.consume("!=") {
+			node = NewNotEqual(node, p.relational())
+		} else {
+			return node
+		}
+	}
+}
+
+func (p *Parser) relational() Node {
+	node := p.shift()
+
+	for {
+		if p.consume("<") {
+			node = NewLessThan(node, p.shift())
+		} else if p.consume("<=") {
+			node = NewLessEqual(node, p.shift())
+		} else if p.consume(">") {
+			node = NewLessThan(p.shift(), node)
+		} else if p.consume(">=") {
+			node = NewLessEqual(p.shift(), node)
+		} else {
+			return node
+		}
+	}
+}
+
+func (p *Parser) shift() Node {
+	node := p.add()
+
+	for {
+		if p.consume("<<") {
+			node = NewShl(node, p.add())
+		} else if p.consume(">>") {
+			node = NewShr(node, p.add())
+		} else {
+			return node
+		}
+	}
+}
+
+func (p *Parser) add() Node {
+	node := p.mul()
+
+	for {
+		if p.consume("+") {
+			node = NewAdd(node, p.mul())
+		} else if p.consume(("-")) {
+			node = NewSub(node, p.mul())
+		} else {
+			return node
+		}
+	}
+}
+
+func (p *Parser) mul() Node {
+	node := p.unary()
+
+	for {
+		if p.consume("*") {
+			node = NewMul(node, p.unary())
+		} else if p.consume("/") {
+			node = NewDiv(node, p.unary())
+		} else {
+			return node
+		}
+	}
+}
+
+func (p *Parser) unary() Node {
+	tok := p.token
+	if p.consume("+") || p.consume("__extension__") {
+		return p.unary()
+	} else if p.consume("-") {
+		return NewSub(NewNumber(0, intType), p.unary())
+	} else if p.consume("!") {
+		return NewNot(p.unary())
+	} else if p.consume("&") {
+		return NewAddress(p.lvalue(p.unary(), tok.next))
+	} else if p.consume("*") {
+		return NewDereference(p.unary(), tok)
+	} else if p.peek("(") && p.isTypeName(tok.next) {
+		p.token = tok.next
+		ty := p.typeName()
+		p.expect(")")
+		return NewCast(p.unary(), ty, tok)
+	} else {
+		return p.postFix()
+	}
+}
+
+func (p *Parser) postFix() Node {
+	node := p.primary()
+
+	for {
+		tok := p.token
+		if p.consume("[") {
+			exp := NewAdd(node, p.expr())
+			p.expect("]")
+			node = NewDereference(exp, tok)
+			continue
+		}
+
+		if p.consume(".") {
+			name := p.expectIdent()
+			node = NewMember(p.lvalue(node, tok), name, tok.next)
+			continue
+		}
+
+		// x->y is short for (*x).y.
+		if p.consume("->") {
+			name := p.expectIdent()
+			node = NewMember(NewDereference(node, tok), name, tok.next)
+			continue
+		}
+
+		if p.consume("(") {
+			node = NewIndirectCall(node, p.funcArgs(), tok)
+			continue
+		}
+		return node
+	}
+}
+
+func (p *Parser) funcArgs() []Node {
+	if p.consume(")") {
+		return nil
+	}
+
+	l := []Node{p.assign()}
+	for p.consume(",") {
+		l = append(l, p.assign())
+	}
+	p.expect(")")
+	return l
+}
+
+func (p *Parser) primary() Node {
+	if p.consume("(") {
+		node := p.expr()
+		p.expect(")")
+		return node
+	}
+
+	if tok := p.token; p.consume("sizeof") {
+		if p.peek("(") && p.isTypeName(p.token.next) {
+			p.token = p.token.next
+			ty := p.typeName()
+			p.expect(")")
+			if isIncomplete(ty) {
+				errorToken(tok, "invalid application of 'sizeof' to incomplete type '%s'", typeString(ty))
+			}
+			return NewNumber(ty.size(), ulongType)
+		}
+		return NewSizeof(p.unary(), tok)
+	}
+
+	if tok := p.token; p.consume("_Alignof") {
+		p.expect("(")
+		if !p.isTypeName(p.token) {
+			errorToken(tok, "_Alignof requires a type name")
+		}
+		ty := p.typeName()
+		p.expect(")")
+		if isIncomplete(ty) {
+			errorToken(tok, "invalid application of '_Alignof' to incomplete type '%s'", typeString(ty))
+		}
+		return NewNumber(ty.align(), ulongType)
+	}
+
+	switch p.token.str {
+	case "__builtin_va_start", "__builtin_va_arg", "__builtin_va_copy", "__builtin_va_end":
+		if p.token.kind == TK_IDENT {
+			return p.vaBuiltin()
+		}
+	}
+
+	if token := p.consumeIdent(); token != nil {
+		// A variable holding a function pointer is called indirectly.
+		if v := p.findVariable(token); (v == nil || v.isTypedef) && p.consume("(") {
+			name := token.str
+			if label := p.asmLabels[name]; label != "" {
+				name = label
+			}
+			args := p.funcArgs()
+			return NewFuncCall(name, args, p.funcTypes[token.str], token)
+		}
+		v := p.findVariable(token)
+		if v == nil && token.str == "__func__" && p.fn != nil {
+			v = p.funcNameVar(token)
+		}
+		if fty := p.funcTypes[token.str]; v == nil && fty != nil {
+			name := token.str
+			if label := p.asmLabels[name]; label != "" {
+				name = label
+			}
+			return NewFuncRef(name, fty)
+		}
+		if v == nil {
+			errorToken(token, "undefined variable '%s'", token.str)
+		}
+		if v.isTypedef {
+			errorToken(token, "expected expression before '%s'", token.str)
+		}
+		v.used = true
+		return NewVarNode(v)
+	}
+
+	tok := p.token
+	if tok.kind == TK_STRING {
+		p.token = p.token.next
+
+		// The literal is terminated by a NUL character.
+		ty := NewArrayType(charType, len(tok.contents)+1)
+		v := p.pushVar(tok, p.newLabel(), ty, false)
+		v.isStatic = true
+		v.contents = tok.contents + "\x00"
+		return NewVarNode(v)
+	}
+
+	tok = p.token
+	return NewNumber(p.expectNumber(), tok.ty)
+}
+
+// vaBuiltin reads a call of one of the builtins that the macros of
+// stdarg.h expand to.
+func (p *Parser) vaBuiltin() Node {
+	tok := p.token
+	p.token = tok.next
+	p.expect("(")
+	var node Node
+	switch tok.str {
+	case "__builtin_va_start":
+		if p.fn == nil || !p.fn.ty.variadic {
+			errorToken(tok, "'va_start' used in function with fixed arguments")
+		}
+		ap := p.assign()
+		// The last parameter is only there for the traditional macro.
+		if p.consume(",") {
+			p.assign()
+		}
+		node = NewVaStart(ap, p.fn, tok)
+	case "__builtin_va_arg":
+		ap := p.assign()
+		p.expect(",")
+		node = NewVaArg(ap, p.typeName(), tok)
+	case "__builtin_va_copy":
+		dst := p.assign()
+		p.expect(",")
+		node = NewVaCopy(dst, p.assign(), tok)
+	default:
+		node = NewVaEnd(p.assign(), tok)
+	}
+	p.expect(")")
+	return node
+}
+
+// funcNameVar returns __func__, which behaves as if each function body
+// started with
+//
+//	static const char __func__[] = "function-name";
+func (p *Parser) funcNameVar(tok *Token) *Variable {
+	if p.funcName == nil {
+		ty := NewArrayType(charType, len(p.fn.name)+1)
+		p.funcName = p.pushVar(tok, p.newLabel(), ty, false)
+		p.funcName.isStatic = true
+		p.funcName.contents = p.fn.name + "\x00"
+	}
+	return p.funcName
+}
+
+func (p *Parser) findVariable(token *Token) *Variable {
+	for i := range p.scope {
+		if token.str == p.scope[i].name {
+			return p.scope[i]
+		}
+	}
+	return nil
+}
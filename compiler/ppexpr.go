@@ -0,0 +1,391 @@
+package compiler
+
+import "strings"
+
+// ppInt is the value of a preprocessor constant expression, which has
+// the type intmax_t or, if unsigned is set, uintmax_t.
+type ppInt struct {
+	val      int64
+	unsigned bool
+}
+
+// ppExpr evaluates the constant expression of an #if or #elif directive
+// after macro expansion.
+type ppExpr struct {
+	tok *Token
+	// skip counts the enclosing operands that are not evaluated, such
+	// as the right side of "0 &&", in which division by zero is allowed.
+	skip int
+}
+
+// evalConstExpr evaluates the expression in line, the tokens of the
+// #if or #elif directive tok, terminated by a TK_EOF token.
+func (pp *preprocessor) evalConstExpr(tok *Token, line *Token) int64 {
+	line = pp.replaceDefined(line)
+	line = pp.preprocess(line)
+	if line.kind == TK_EOF {
+		errorToken(tok, "#%s with no expression", tok.str)
+	}
+
+	for t := line; t.kind != TK_EOF; t = t.next {
+		switch {
+		case t.kind == TK_IDENT:
+			// Identifiers left after macro expansion are 0.
+			t.kind = TK_NUM
+			t.val = 0
+		case t.kind == TK_NUM && !isCharLiteral(t):
+			if strings.ContainsRune(t.str, '.') {
+				errorToken(t, "floating constant in preprocessor expression")
+			}
+			convertNumber(t, pp.diag)
+		case t.kind == TK_STRING:
+			errorToken(t, "token \"%s\" is not valid in preprocessor expressions", t.str)
+		}
+	}
+
+	e := &ppExpr{tok: line}
+	val := e.conditional()
+	if e.tok.kind != TK_EOF {
+		errorToken(e.tok, "missing binary operator before token \"%s\"", e.tok.str)
+	}
+	return val.val
+}
+
+// replaceDefined replaces each "defined NAME" and "defined(NAME)" in
+// line by 1 if NAME is a macro and 0 otherwise.
+func (pp *preprocessor) replaceDefined(line *Token) *Token {
+	head := &Token{}
+	cur := head
+	t := line
+	for ; t.kind != TK_EOF; t = t.next {
+		if t.kind != TK_IDENT || t.str != "defined" {
+			cur.next = t
+			cur = t
+			continue
+		}
+
+		start := t
+		t = t.next
+		paren := t.kind == TK_RESERVED && t.str == "("
+		if paren {
+			t = t.next
+		}
+		if t.kind != TK_IDENT {
+			errorToken(start, "operator \"defined\" requires an identifier")
+		}
+		val := "0"
+		if pp.macros[t.str] != nil {
+			val = "1"
+		}
+		if paren {
+			t = t.next
+			if t.kind != TK_RESERVED || t.str != ")" {
+				errorToken(start, "missing ')' after \"defined\"")
+			}
+		}
+
+		num := *start
+		num.kind = TK_NUM
+		num.str = val
+		num.next = nil
+		cur.next = &num
+		cur = &num
+	}
+	cur.next = t
+	return head.next
+}
+
+func (e *ppExpr) consume(op string) bool {
+	if e.tok.kind != TK_RESERVED || e.tok.str != op {
+		return false
+	}
+	e.tok = e.tok.next
+	return true
+}
+
+// usualConversions converts the operands of a binary operator to
+// uintmax_t if either is unsigned.
+func usualConversions(a *ppInt, b *ppInt) bool {
+	if a.unsigned || b.unsigned {
+		a.unsigned = true
+		b.unsigned = true
+	}
+	return a.unsigned
+}
+
+func boolValue(b bool) ppInt {
+	if b {
+		return ppInt{val: 1}
+	}
+	return ppInt{}
+}
+
+// conditional = logOr ("?" conditional ":" conditional)?
+func (e *ppExpr) conditional() ppInt {
+	cond := e.logOr()
+	if !e.consume("?") {
+		return cond
+	}
+	if cond.val == 0 {
+		e.skip++
+	}
+	then := e.conditional()
+	if cond.val == 0 {
+		e.skip--
+	}
+	if !e.consume(":") {
+		errorToken(e.tok, "expected ':' in preprocessor expression")
+	}
+	if cond.val != 0 {
+		e.skip++
+	}
+	els := e.conditional()
+	if cond.val != 0 {
+		e.skip--
+	}
+	usualConversions(&then, &els)
+	if cond.val != 0 {
+		return then
+	}
+	return els
+}
+
+// logOr = logAnd ("||" logAnd)*
+func (e *ppExpr) logOr() ppInt {
+	lhs := e.logAnd()
+	for e.consume("||") {
+		if lhs.val != 0 {
+			e.skip++
+		}
+		rhs := e.logAnd()
+		if lhs.val != 0 {
+			e.skip--
+		}
+		lhs = boolValue(lhs.val != 0 || rhs.val != 0)
+	}
+	return lhs
+}
+
+// logAnd = bitOr ("&&" bitOr)*
+func (e *ppExpr) logAnd() ppInt {
+	lhs := e.bitOr()
+	for e.consume("&&") {
+		if lhs.val == 0 {
+			e.skip++
+		}
+		rhs := e.bitOr()
+		if lhs.val == 0 {
+			e.skip--
+		}
+		lhs = boolValue(lhs.val != 0 && rhs.val != 0)
+	}
+	return lhs
+}
+
+// bitOr = bitXor ("|" bitXor)*
+func (e *ppExpr) bitOr() ppInt {
+	lhs := e.bitXor()
+	for e.consume("|") {
+		rhs := e.bitXor()
+		usualConversions(&lhs, &rhs)
+		lhs.val |= rhs.val
+	}
+	return lhs
+}
+
+// bitXor = bitAnd ("^" bitAnd)*
+func (e *ppExpr) bitXor() ppInt {
+	lhs := e.bitAnd()
+	for e.consume("^") {
+		rhs := e.bitAnd()
+		usualConversions(&lhs, &rhs)
+		lhs.val ^= rhs.val
+	}
+	return lhs
+}
+
+// bitAnd = equality ("&" equality)*
+func (e *ppExpr) bitAnd() ppInt {
+	lhs := e.equality()
+	for e.consume("&") {
+		rhs := e.equality()
+		usualConversions(&lhs, &rhs)
+		lhs.val &= rhs.val
+	}
+	return lhs
+}
+
+// equality = relational ("==" relational | "!=" relational)*
+func (e *ppExpr) equality() ppInt {
+	lhs := e.relational()
+	for {
+		switch {
+		case e.consume("=="):
+			rhs := e.relational()
+			lhs = boolValue(lhs.val == rhs.val)
+		case e.consume("!="):
+			rhs := e.relational()
+			lhs = boolValue(lhs.val != rhs.val)
+		default:
+			return lhs
+		}
+	}
+}
+
+// less reports whether a < b after the usual conversions.
+func less(a ppInt, b ppInt) bool {
+	if usualConversions(&a, &b) {
+		return uint64(a.val) < uint64(b.val)
+	}
+	return a.val < b.val
+}
+
+// relational = shift ("<" shift | "<=" shift | ">" shift | ">=" shift)*
+func (e *ppExpr) relational() ppInt {
+	lhs := e.shift()
+	for {
+		switch {
+		case e.consume("<"):
+			rhs := e.shift()
+			lhs = boolValue(less(lhs, rhs))
+		case e.consume("<="):
+			rhs := e.shift()
+			lhs = boolValue(!less(rhs, lhs))
+		case e.consume(">"):
+			rhs := e.shift()
+			lhs = boolValue(less(rhs, lhs))
+		case e.consume(">="):
+			rhs := e.shift()
+			lhs = boolValue(!less(lhs, rhs))
+		default:
+			return lhs
+		}
+	}
+}
+
+// shift = add ("<<" add | ">>" add)*
+//
+// The result has the type of the left operand.
+func (e *ppExpr) shift() ppInt {
+	lhs := e.add()
+	for {
+		switch {
+		case e.consume("<<"):
+			rhs := e.add()
+			lhs.val <<= uint64(rhs.val) & 63
+		case e.consume(">>"):
+			rhs := e.add()
+			if lhs.unsigned {
+				lhs.val = int64(uint64(lhs.val) >> (uint64(rhs.val) & 63))
+			} else {
+				lhs.val >>= uint64(rhs.val) & 63
+			}
+		default:
+			return lhs
+		}
+	}
+}
+
+// add = mul ("+" mul | "-" mul)*
+func (e *ppExpr) add() ppInt {
+	lhs := e.mul()
+	for {
+		switch {
+		case e.consume("+"):
+			rhs := e.mul()
+			usualConversions(&lhs, &rhs)
+			lhs.val += rhs.val
+		case e.consume("-"):
+			rhs := e.mul()
+			usualConversions(&lhs, &rhs)
+			lhs.val -= rhs.val
+		default:
+			return lhs
+		}
+	}
+}
+
+// mul = unary ("*" unary | "/" unary | "%" unary)*
+func (e *ppExpr) mul() ppInt {
+	lhs := e.unary()
+	for {
+		op := e.tok
+		switch {
+		case e.consume("*"):
+			rhs := e.unary()
+			usualConversions(&lhs, &rhs)
+			lhs.val *= rhs.val
+		case e.consume("/"), e.consume("%"):
+			rhs := e.unary()
+			unsigned := usualConversions(&lhs, &rhs)
+			if rhs.val == 0 {
+				if e.skip == 0 {
+					errorToken(op, "division by zero in #if")
+				}
+				lhs.val = 0
+				continue
+			}
+			switch {
+			case unsigned && op.str == "/":
+				lhs.val = int64(uint64(lhs.val) / uint64(rhs.val))
+			case unsigned:
+				lhs.val = int64(uint64(lhs.val) % uint64(rhs.val))
+			case rhs.val == -1:
+				// Avoid the overflow of the minimum value divided by -1.
+				if op.str == "/" {
+					lhs.val = -lhs.val
+				} else {
+					lhs.val = 0
+				}
+			case op.str == "/":
+				lhs.val /= rhs.val
+			default:
+				lhs.val %= rhs.val
+			}
+		default:
+			return lhs
+		}
+	}
+}
+
+// unary = ("+" | "-" | "~" | "!") unary | primary
+func (e *ppExpr) unary() ppInt {
+	switch {
+	case e.consume("+"):
+		return e.unary()
+	case e.consume("-"):
+		v := e.unary()
+		v.val = -v.val
+		return v
+	case e.consume("~"):
+		v := e.unary()
+		v.val = ^v.val
+		return v
+	case e.consume("!"):
+		return boolValue(e.unary().val == 0)
+	}
+	return e.primary()
+}
+
+// primary = "(" conditional ")" | num
+func (e *ppExpr) primary() ppInt {
+	tok := e.tok
+	if e.consume("(") {
+		v := e.conditional()
+		if !e.consume(")") {
+			errorToken(e.tok, "missing ')' in expression")
+		}
+		return v
+	}
+	if tok.kind != TK_NUM {
+		if tok.kind == TK_EOF {
+			errorToken(tok, "expected value in expression")
+		}
+		errorToken(tok, "token \"%s\" is not valid in preprocessor expressions", tok.str)
+	}
+	e.tok = tok.next
+	// A constant is unsigned with a u suffix or if it does not fit in
+	// intmax_t.
+	unsigned := !isCharLiteral(tok) && (tok.val < 0 || strings.ContainsAny(tok.str, "uU"))
+	return ppInt{val: int64(tok.val), unsigned: unsigned}
+}
@@ -0,0 +1,1117 @@
+package compiler
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxIncludeDepth is the deepest #include nesting allowed, as in gcc.
+const maxIncludeDepth = 200
+
+// builtinHeaders holds the standard headers shipped with the compiler,
+// which are searched after the -I directories.
+//
+//go:embed include
+var builtinHeaders embed.FS
+
+// builtinIncludeDir is the name of the directory of builtinHeaders in
+// file names and diagnostics.
+const builtinIncludeDir = "<gocc>/include"
+
+// builtinPath returns the name of the file at path within builtinHeaders
+// and whether path is in builtinIncludeDir.
+func builtinPath(path string) (string, bool) {
+	if !strings.HasPrefix(path, builtinIncludeDir+"/") {
+		return "", false
+	}
+	return "include/" + strings.TrimPrefix(path, builtinIncludeDir+"/"), true
+}
+
+// fileExists reports whether path names a regular file.
+func fileExists(path string) bool {
+	if name, ok := builtinPath(path); ok {
+		_, err := builtinHeaders.ReadFile(name)
+		return err == nil
+	}
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// readFile returns the contents of the file at path.
+func readFile(path string) ([]byte, error) {
+	if name, ok := builtinPath(path); ok {
+		return builtinHeaders.ReadFile(name)
+	}
+	return os.ReadFile(path)
+}
+
+// macro is a macro defined with #define.
+type macro struct {
+	name string
+	// body is the replacement list, terminated by a TK_EOF token.
+	body *Token
+
+	// Whether the macro takes arguments, and its parameters
+	funcLike bool
+	params   []string
+	// Whether the last parameter is "...", named __VA_ARGS__ in body
+	variadic bool
+
+	// handler, if non-nil, computes the expansion of a dynamic macro
+	// such as __LINE__ from its name.
+	handler func(tok *Token) *Token
+}
+
+// hideset is the set of macros that must not be expanded at a token
+// because the token came from expanding them.
+type hideset struct {
+	next *hideset
+	name string
+}
+
+func (hs *hideset) contains(name string) bool {
+	for ; hs != nil; hs = hs.next {
+		if hs.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// union returns a set with the names in either hs or other.
+func (hs *hideset) union(other *hideset) *hideset {
+	for ; hs != nil; hs = hs.next {
+		if !other.contains(hs.name) {
+			other = &hideset{next: other, name: hs.name}
+		}
+	}
+	return other
+}
+
+// intersect returns a set with the names in both hs and other.
+func (hs *hideset) intersect(other *hideset) *hideset {
+	var result *hideset
+	for ; hs != nil; hs = hs.next {
+		if other.contains(hs.name) {
+			result = &hideset{next: result, name: hs.name}
+		}
+	}
+	return result
+}
+
+// condIncl is an #if, #ifdef or #ifndef whose #endif has not been seen
+// yet.
+type condIncl struct {
+	// tok is the name of the latest directive of the group, such as
+	// "if" or "else".
+	tok *Token
+	// Whether a group has been included, so the rest are skipped
+	included bool
+}
+
+// preprocessor expands macros and executes directives in a token list
+// produced by Tokenize.
+type preprocessor struct {
+	diag   *diagnostics
+	macros map[string]*macro
+	// includePaths are the directories searched by #include.
+	includePaths []string
+	includeDepth int
+
+	// conds are the open conditionals, of which those from condBase on
+	// are in the file being preprocessed.
+	conds    []*condIncl
+	condBase int
+
+	// foundIn maps the files found in the search path to the index of
+	// their directory in it.
+	foundIn map[string]int
+	// onceFiles are the files containing #pragma once.
+	onceFiles map[string]bool
+	// included is Options.Included.
+	included func(path string)
+}
+
+// A MacroDef defines or undefines a macro before the source is
+// preprocessed, like the -D and -U options.
+type MacroDef struct {
+	// Name is the name of the macro, followed by its parameter list
+	// for a function-like macro.
+	Name string
+	// Value is the replacement list. It is "1" for -DNAME.
+	Value string
+	// Undef undefines Name instead, like -U.
+	Undef bool
+}
+
+// predefinedMacros are the macros defined before any source is read,
+// other than __DATE__, __TIME__, __FILE__ and __LINE__.
+const predefinedMacros = `#define __STDC__ 1
+#define __STDC_VERSION__ 201112L
+#define __STDC_HOSTED__ 1
+#define __x86_64__ 1
+#define __x86_64 1
+#define __LP64__ 1
+#define _LP64 1
+#define __linux__ 1
+#define __unix__ 1
+#define __ELF__ 1
+#define __USER_LABEL_PREFIX__
+`
+
+func newPreprocessor(diag *diagnostics, opts Options) *preprocessor {
+	pp := &preprocessor{
+		diag:         diag,
+		macros:       map[string]*macro{},
+		includePaths: opts.IncludePaths,
+		foundIn:      map[string]int{},
+		onceFiles:    map[string]bool{},
+		included:     opts.Included,
+	}
+
+	now := time.Now()
+	builtin := predefinedMacros +
+		fmt.Sprintf("#define __DATE__ \"%s\"\n", now.Format("Jan _2 2006")) +
+		fmt.Sprintf("#define __TIME__ \"%s\"\n", now.Format("15:04:05"))
+	pp.preprocess(Tokenize(&File{Name: "<built-in>", Contents: builtin}, diag))
+	pp.macros["__FILE__"] = &macro{name: "__FILE__", handler: pp.fileMacro}
+	pp.macros["__LINE__"] = &macro{name: "__LINE__", handler: pp.lineMacro}
+
+	var b strings.Builder
+	for _, d := range opts.Macros {
+		if d.Undef {
+			fmt.Fprintf(&b, "#undef %s\n", d.Name)
+		} else {
+			fmt.Fprintf(&b, "#define %s %s\n", d.Name, d.Value)
+		}
+	}
+	pp.preprocess(Tokenize(&File{Name: "<command-line>", Contents: b.String()}, diag))
+	return pp
+}
+
+// expansionRoot returns the token in the source whose macro expansion
+// produced tok.
+func expansionRoot(tok *Token) *Token {
+	for tok.origin != nil {
+		tok = tok.origin
+	}
+	return tok
+}
+
+// fileMacro expands __FILE__ at tok to the name of the current file.
+func (pp *preprocessor) fileMacro(tok *Token) *Token {
+	root := expansionRoot(tok)
+	name, _ := root.file.presumed(root.pos, root.line)
+	return pp.newStringToken(name, tok)
+}
+
+// lineMacro expands __LINE__ at tok to the current line number.
+func (pp *preprocessor) lineMacro(tok *Token) *Token {
+	root := expansionRoot(tok)
+	_, line := root.file.presumed(root.pos, root.line)
+	return pp.newTokenFrom(strconv.Itoa(line), tok)
+}
+
+// isHash reports whether tok starts a directive. A '#' produced by a
+// macro expansion never does.
+func isHash(tok *Token) bool {
+	return tok.atBOL && tok.origin == nil && tok.kind == TK_RESERVED && tok.str == "#"
+}
+
+// skipLine returns the first token on the next line.
+func skipLine(tok *Token) *Token {
+	for !tok.atBOL {
+		tok = tok.next
+	}
+	return tok
+}
+
+// newEOF returns an end-of-input token at the position of tok.
+func newEOF(tok *Token) *Token {
+	eof := *tok
+	eof.kind = TK_EOF
+	eof.len = 0
+	eof.str = ""
+	eof.next = nil
+	return &eof
+}
+
+// copyLine returns a copy of the tokens up to the end of the line,
+// terminated by a TK_EOF token at the end of the last of them, and the
+// first token on the next line.
+func copyLine(tok *Token) (*Token, *Token) {
+	head := &Token{}
+	cur := head
+	for ; !tok.atBOL; tok = tok.next {
+		t := *tok
+		cur.next = &t
+		cur = cur.next
+	}
+	if cur == head {
+		cur.next = newEOF(tok)
+		return head.next, tok
+	}
+	eof := newEOF(cur)
+	eof.pos += cur.len
+	eof.col += cur.len
+	eof.atBOL = true
+	cur.next = eof
+	return head.next, tok
+}
+
+// expectLineEnd reports any tokens left on the line of a directive and
+// returns the first token on the next line.
+func (pp *preprocessor) expectLineEnd(tok *Token, directive string) *Token {
+	if !tok.atBOL {
+		pp.diag.add(tokenError(tok, "extra tokens at end of #%s directive", directive))
+	}
+	return skipLine(tok)
+}
+
+// preprocess expands the macros and executes the directives in tok,
+// returning the resulting tokens.
+func (pp *preprocessor) preprocess(tok *Token) *Token {
+	base := pp.condBase
+	pp.condBase = len(pp.conds)
+	defer func() { pp.condBase = base }()
+
+	head := &Token{}
+	cur := head
+	for tok.kind != TK_EOF {
+		var next *Token
+		var expanded bool
+		// A macro invocation with an error is left unexpanded, as in gcc.
+		ok := pp.diag.try(func() { next, expanded = pp.expandMacro(tok) })
+		if ok && expanded {
+			tok = next
+			continue
+		}
+		if !isHash(tok) {
+			cur.next = tok
+			cur = tok
+			tok = tok.next
+			continue
+		}
+
+		hash := tok
+		cur.next = nil
+		if !pp.diag.try(func() { tok = pp.directive(hash.next, cur) }) {
+			tok = skipLine(hash.next)
+		}
+		for cur.next != nil {
+			cur = cur.next
+		}
+	}
+
+	for _, c := range pp.conds[pp.condBase:] {
+		pp.diag.add(tokenError(c.tok, "unterminated #%s", c.tok.str))
+	}
+	pp.conds = pp.conds[:pp.condBase]
+	cur.next = tok
+	return head.next
+}
+
+// preprocessFile tokenizes and preprocesses file. The diagnostics about
+// the tokens of groups skipped by conditionals are dropped, since the
+// contents of a skipped group need not be valid tokens.
+func (pp *preprocessor) preprocessFile(file *File) *Token {
+	tok := tokenize(file, pp.diag)
+	lexed := []*Token{}
+	for t := tok; t != nil; t = t.next {
+		if t.diags != nil {
+			lexed = append(lexed, t)
+		}
+	}
+	tok = pp.preprocess(tok)
+	for _, t := range lexed {
+		reportLexical(t, pp.diag)
+	}
+	return tok
+}
+
+// directive executes the directive whose name is tok. Any tokens it
+// produces are appended after cur. It returns the first token after the
+// directive.
+func (pp *preprocessor) directive(tok *Token, cur *Token) *Token {
+	// A '#' alone on a line is the null directive.
+	if tok.atBOL {
+		return tok
+	}
+	// # 12 "foo.c" is a line marker like those printed by -E.
+	if tok.kind == TK_NUM {
+		return pp.lineControl(tok, tok)
+	}
+
+	switch tok.str {
+	case "include", "include_next":
+		path, rest := pp.includeFilename(tok.next, tok)
+		cur.next = pp.includeFile(path, tok)
+		return rest
+	case "define":
+		return pp.define(tok.next)
+	case "undef":
+		name := tok.next
+		if name.atBOL || name.kind != TK_IDENT {
+			errorToken(name, "macro names must be identifiers")
+		}
+		delete(pp.macros, name.str)
+		return pp.expectLineEnd(name.next, "undef")
+	case "if":
+		val, rest := pp.readCondition(tok)
+		return pp.beginGroup(tok, val, rest)
+	case "ifdef", "ifndef":
+		name := tok.next
+		if name.atBOL || name.kind != TK_IDENT {
+			// The group is skipped, as in gcc.
+			pp.diag.add(tokenError(tok, "no macro name given in #%s directive", tok.str))
+			return pp.beginGroup(tok, false, skipLine(name))
+		}
+		defined := pp.macros[name.str] != nil
+		return pp.beginGroup(tok, defined == (tok.str == "ifdef"), pp.expectLineEnd(name.next, tok.str))
+	case "elif":
+		c := pp.currentCond(tok)
+		if c.tok.str == "else" {
+			errorToken(tok, "#elif after #else")
+		}
+		c.tok = tok
+		if c.included {
+			return skipGroup(skipLine(tok))
+		}
+		val, rest := pp.readCondition(tok)
+		if !val {
+			return skipGroup(rest)
+		}
+		c.included = true
+		return rest
+	case "else":
+		c := pp.currentCond(tok)
+		if c.tok.str == "else" {
+			errorToken(tok, "#else after #else")
+		}
+		c.tok = tok
+		rest := pp.expectLineEnd(tok.next, "else")
+		if c.included {
+			return skipGroup(rest)
+		}
+		c.included = true
+		return rest
+	case "endif":
+		pp.currentCond(tok)
+		pp.conds = pp.conds[:len(pp.conds)-1]
+		return pp.expectLineEnd(tok.next, "endif")
+	case "line":
+		return pp.lineControl(tok.next, tok)
+	case "pragma":
+		if t := tok.next; !t.atBOL && t.str == "once" {
+			pp.onceFiles[filepath.Clean(tok.file.Name)] = true
+			return pp.expectLineEnd(t.next, "pragma once")
+		}
+		// Other pragmas are ignored.
+		return skipLine(tok)
+	}
+	errorToken(tok, "invalid preprocessing directive #%s", tok.str)
+	return nil
+}
+
+// beginGroup opens a conditional at the directive tok whose first group
+// is included if val is true, and returns the first token after it
+// that is not skipped.
+func (pp *preprocessor) beginGroup(tok *Token, val bool, rest *Token) *Token {
+	pp.conds = append(pp.conds, &condIncl{tok: tok, included: val})
+	if !val {
+		return skipGroup(rest)
+	}
+	return rest
+}
+
+// currentCond returns the innermost open conditional of the directive
+// tok, such as #else.
+func (pp *preprocessor) currentCond(tok *Token) *condIncl {
+	if len(pp.conds) == pp.condBase {
+		errorToken(tok, "#%s without #if", tok.str)
+	}
+	return pp.conds[len(pp.conds)-1]
+}
+
+// skipGroup skips a group excluded by a conditional and returns the '#'
+// of the #elif, #else or #endif that ends it.
+func skipGroup(tok *Token) *Token {
+	depth := 0
+	for ; tok.kind != TK_EOF; tok = tok.next {
+		tok.diags = nil
+		if !isHash(tok) || tok.next.atBOL {
+			continue
+		}
+		switch tok.next.str {
+		case "if", "ifdef", "ifndef":
+			depth++
+		case "elif", "else":
+			if depth == 0 {
+				return tok
+			}
+		case "endif":
+			if depth == 0 {
+				return tok
+			}
+			depth--
+		}
+	}
+	return tok
+}
+
+// lineControl executes the #line directive whose name is directive and
+// operands start at tok, or the line marker whose line number is tok.
+func (pp *preprocessor) lineControl(tok *Token, directive *Token) *Token {
+	line, rest := copyLine(tok)
+	end := line
+	for end.kind != TK_EOF {
+		end = end.next
+	}
+	// The operands of #line, but not of a line marker, are expanded.
+	isMarker := directive.kind == TK_NUM
+	if !isMarker {
+		line = pp.preprocess(line)
+	}
+
+	num := line
+	n, err := strconv.Atoi(num.str)
+	if num.kind != TK_NUM || err != nil || strings.Trim(num.str, "0123456789") != "" {
+		errorToken(num, "\"%s\" after #line is not a positive integer", num.str)
+	}
+	file := directive.file
+	name, _ := file.presumed(directive.pos, directive.line)
+	t := num.next
+	if t.kind == TK_STRING {
+		name = t.contents
+		t = t.next
+	}
+	// Flags after a line marker are ignored.
+	for isMarker && t.kind == TK_NUM {
+		t = t.next
+	}
+	if t.kind != TK_EOF {
+		errorToken(t, "extra tokens at end of #line directive")
+	}
+
+	// The marker applies from the start of the line after the directive.
+	start := len(file.Contents)
+	if i := strings.IndexByte(file.Contents[end.pos:], '\n'); i >= 0 {
+		start = end.pos + i + 1
+	}
+	file.markers = append(file.markers, lineMarker{pos: start, physLine: end.line + 1, line: n, name: name})
+	return rest
+}
+
+// readCondition reads and evaluates the expression of the #if or #elif
+// directive tok, returning its value and the first token after the
+// directive. An invalid expression is reported and taken to be false.
+func (pp *preprocessor) readCondition(tok *Token) (bool, *Token) {
+	line, rest := copyLine(tok.next)
+	val := false
+	pp.diag.try(func() { val = pp.evalConstExpr(tok, line) != 0 })
+	return val, rest
+}
+
+// define reads the definition of the macro whose name is tok. The name
+// of a function-like macro is followed by '(' without whitespace in
+// between.
+func (pp *preprocessor) define(tok *Token) *Token {
+	if tok.atBOL || tok.kind != TK_IDENT {
+		errorToken(tok, "macro names must be identifiers")
+	}
+	m := &macro{name: tok.str}
+	tok = tok.next
+	if !tok.atBOL && !tok.hasSpace && tok.str == "(" {
+		m.funcLike = true
+		tok = pp.macroParams(m, tok.next)
+	}
+	body, rest := copyLine(tok)
+	checkMacroBody(m, body)
+	m.body = body
+	pp.macros[m.name] = m
+	return rest
+}
+
+// macroParams reads the parameter list of the function-like macro m,
+// starting after its '('. It returns the token after the ')'.
+func (pp *preprocessor) macroParams(m *macro, tok *Token) *Token {
+	if !tok.atBOL && tok.str == ")" {
+		return tok.next
+	}
+	for {
+		if tok.atBOL {
+			errorToken(tok, "missing ')' in macro parameter list")
+		}
+		if tok.str == "..." {
+			m.variadic = true
+			tok = tok.next
+			if tok.atBOL || tok.str != ")" {
+				errorToken(tok, "missing ')' in macro parameter list")
+			}
+			return tok.next
+		}
+		if tok.kind != TK_IDENT {
+			errorToken(tok, "expected parameter name, found \"%s\"", tok.str)
+		}
+		for _, p := range m.params {
+			if p == tok.str {
+				errorToken(tok, "duplicate macro parameter \"%s\"", tok.str)
+			}
+		}
+		m.params = append(m.params, tok.str)
+		tok = tok.next
+		if !tok.atBOL && tok.str == ")" {
+			return tok.next
+		}
+		if tok.atBOL || tok.str != "," {
+			errorToken(tok, "expected ',' or ')', found \"%s\"", tok.str)
+		}
+		tok = tok.next
+	}
+}
+
+// checkMacroBody reports misplaced '#' and '##' operators in the
+// replacement list body of m.
+func checkMacroBody(m *macro, body *Token) {
+	for t := body; t.kind != TK_EOF; t = t.next {
+		if t.kind != TK_RESERVED {
+			continue
+		}
+		if t.str == "##" && (t == body || t.next.kind == TK_EOF) {
+			errorToken(t, "'##' cannot appear at either end of a macro expansion")
+		}
+		if t.str == "#" && m.funcLike && m.param(t.next) < 0 {
+			errorToken(t, "'#' is not followed by a macro parameter")
+		}
+	}
+}
+
+// param returns the index of the parameter named by tok, or -1 if tok
+// is not a parameter of m. __VA_ARGS__ follows the named parameters.
+func (m *macro) param(tok *Token) int {
+	if tok.kind != TK_IDENT {
+		return -1
+	}
+	for i, p := range m.params {
+		if p == tok.str {
+			return i
+		}
+	}
+	if m.variadic && tok.str == "__VA_ARGS__" {
+		return len(m.params)
+	}
+	return -1
+}
+
+// readMacroArgs reads the arguments of an invocation of m whose '(' is
+// tok. Each argument is terminated by a TK_EOF token. It returns the
+// arguments and the closing ')'.
+func readMacroArgs(m *macro, name *Token, tok *Token) ([]*Token, *Token) {
+	args := []*Token{}
+	head := &Token{}
+	cur := head
+	level := 0
+	for tok = tok.next; ; tok = tok.next {
+		if tok.kind == TK_EOF {
+			errorToken(name, "unterminated argument list invoking macro \"%s\"", m.name)
+		}
+		if tok.kind == TK_RESERVED {
+			switch {
+			case tok.str == "(":
+				level++
+			case tok.str == ")" && level > 0:
+				level--
+			case tok.str == ")":
+				cur.next = newEOF(tok)
+				return append(args, head.next), tok
+			case tok.str == "," && level == 0 && (!m.variadic || len(args) < len(m.params)):
+				// The variable arguments include their commas.
+				cur.next = newEOF(tok)
+				args = append(args, head.next)
+				cur = head
+				continue
+			}
+		}
+		t := *tok
+		t.next = nil
+		cur.next = &t
+		cur = cur.next
+	}
+}
+
+// checkMacroArgs checks that the number of arguments matches the
+// parameters of m, adding an empty argument for omitted variable
+// arguments.
+func checkMacroArgs(m *macro, name *Token, args []*Token, rparen *Token) []*Token {
+	// f() passes one empty argument, which is also no arguments.
+	if len(m.params) == 0 && !m.variadic && len(args) == 1 && args[0].kind == TK_EOF {
+		return nil
+	}
+	if m.variadic && len(args) == len(m.params) {
+		return append(args, newEOF(rparen))
+	}
+	nparams := len(m.params)
+	if m.variadic {
+		nparams++
+	}
+	if len(args) < nparams {
+		errorToken(name, "macro \"%s\" requires %d arguments, but only %d given", m.name, nparams, len(args))
+	}
+	if len(args) > nparams {
+		errorToken(name, "macro \"%s\" passed %d arguments, but takes just %d", m.name, len(args), nparams)
+	}
+	return args
+}
+
+// copyTokens returns a copy of the list tok, including its TK_EOF.
+func copyTokens(tok *Token) *Token {
+	head := &Token{}
+	cur := head
+	for ; ; tok = tok.next {
+		t := *tok
+		t.next = nil
+		cur.next = &t
+		cur = cur.next
+		if tok.kind == TK_EOF {
+			return head.next
+		}
+	}
+}
+
+// quote returns s as a C string literal.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' || s[i] == '"' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// newStringToken returns a string literal token with contents s at the
+// position of tmpl.
+func (pp *preprocessor) newStringToken(s string, tmpl *Token) *Token {
+	return pp.newTokenFrom(quote(s), tmpl)
+}
+
+// newTokenFrom returns the token spelled src at the position of tmpl.
+func (pp *preprocessor) newTokenFrom(src string, tmpl *Token) *Token {
+	tok := Tokenize(&File{Name: tmpl.file.Name, Contents: src}, pp.diag)
+	tok.file, tok.pos, tok.len = tmpl.file, tmpl.pos, tmpl.len
+	tok.line, tok.col = tmpl.line, tmpl.col
+	tok.hasSpace = tmpl.hasSpace
+	tok.atBOL = false
+	tok.next = nil
+	return tok
+}
+
+// stringize implements the # operator at hash applied to arg.
+func (pp *preprocessor) stringize(hash *Token, arg *Token) *Token {
+	var b strings.Builder
+	for t := arg; t.kind != TK_EOF; t = t.next {
+		if t != arg && (t.hasSpace || t.atBOL) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.str)
+	}
+	return pp.newStringToken(b.String(), hash)
+}
+
+// paste implements the ## operator, returning the token spelled by lhs
+// followed by rhs. If they do not form a single token, it reports an
+// error and returns nil, and the operands are left as they are.
+func (pp *preprocessor) paste(lhs *Token, rhs *Token) *Token {
+	buf := lhs.str + rhs.str
+	tok := Tokenize(&File{Name: lhs.file.Name, Contents: buf}, pp.diag)
+	if tok.kind == TK_EOF || tok.next.kind != TK_EOF {
+		pp.diag.add(tokenError(lhs, "pasting \"%s\" and \"%s\" does not give a valid preprocessing token", lhs.str, rhs.str))
+		return nil
+	}
+	t := *lhs
+	t.kind, t.str, t.contents = tok.kind, tok.str, tok.contents
+	t.next = nil
+	return &t
+}
+
+// subst returns the replacement list of m with the parameters replaced
+// by args and the # and ## operators applied. Tokens from the
+// replacement list get origin as the place of the expansion.
+func (pp *preprocessor) subst(m *macro, args []*Token, origin *Token) *Token {
+	arg := func(tok *Token) *Token {
+		if !m.funcLike {
+			return nil
+		}
+		if i := m.param(tok); i >= 0 {
+			return args[i]
+		}
+		return nil
+	}
+	isOp := func(tok *Token, op string) bool {
+		return tok.kind == TK_RESERVED && tok.str == op
+	}
+
+	head := &Token{}
+	cur := head
+	// Arguments may span lines, but their tokens are no longer at the
+	// beginning of one in the expansion.
+	appendTokens := func(tok *Token) {
+		for ; tok.kind != TK_EOF; tok = tok.next {
+			t := *tok
+			t.atBOL = false
+			t.next = nil
+			cur.next = &t
+			cur = cur.next
+		}
+	}
+	appendToken := func(tok *Token) {
+		t := *tok
+		t.origin = origin
+		t.next = nil
+		cur.next = &t
+		cur = cur.next
+	}
+
+	tok := m.body
+	for tok.kind != TK_EOF {
+		// # x
+		if m.funcLike && isOp(tok, "#") {
+			s := pp.stringize(tok, arg(tok.next))
+			s.origin = origin
+			cur.next = s
+			cur = s
+			tok = tok.next.next
+			continue
+		}
+
+		// ## x, where cur is the left operand
+		if isOp(tok, "##") {
+			rhs := tok.next
+			a := arg(rhs)
+			if a != nil {
+				rhs = a
+			}
+			var t *Token
+			if cur != head && rhs.kind != TK_EOF {
+				t = pp.paste(cur, rhs)
+			}
+			switch {
+			case t != nil && a != nil:
+				*cur = *t
+				appendTokens(a.next)
+			case t != nil:
+				*cur = *t
+			case a != nil:
+				appendTokens(a)
+			default:
+				appendToken(rhs)
+			}
+			tok = tok.next.next
+			continue
+		}
+
+		// , ## __VA_ARGS__ is __VA_ARGS__ after the comma, or nothing if
+		// there are no variable arguments, as in gcc.
+		if m.variadic && isOp(tok, ",") && isOp(tok.next, "##") && tok.next.next.str == "__VA_ARGS__" {
+			if args[len(m.params)].kind == TK_EOF {
+				tok = tok.next.next.next
+			} else {
+				appendToken(tok)
+				tok = tok.next.next
+			}
+			continue
+		}
+
+		a := arg(tok)
+		// x ##: the argument is pasted without being expanded. If it is
+		// empty, the right operand is used as is.
+		if a != nil && isOp(tok.next, "##") {
+			if rhs := tok.next.next; a.kind == TK_EOF {
+				if b := arg(rhs); b != nil {
+					appendTokens(b)
+				} else {
+					appendToken(rhs)
+				}
+				tok = rhs.next
+				continue
+			}
+			appendTokens(a)
+			tok = tok.next
+			continue
+		}
+		// x: the argument is fully expanded before substitution.
+		if a != nil {
+			expanded := pp.preprocess(copyTokens(a))
+			expanded.hasSpace = tok.hasSpace
+			appendTokens(expanded)
+			tok = tok.next
+			continue
+		}
+
+		appendToken(tok)
+		tok = tok.next
+	}
+	cur.next = nil
+	return head.next
+}
+
+// expandMacro expands tok if it names a macro, returning the tokens of
+// the expansion followed by the rest of the input. The hideset of each
+// resulting token prevents the macros it came from from being expanded
+// again.
+func (pp *preprocessor) expandMacro(tok *Token) (*Token, bool) {
+	if tok.kind != TK_IDENT || tok.hideset.contains(tok.str) {
+		return nil, false
+	}
+	m := pp.macros[tok.str]
+	if m == nil {
+		return nil, false
+	}
+
+	// Tokens in the expansion keep the location of their spelling in
+	// the definition; origin records where the macro was used.
+	var body *Token
+	var hs *hideset
+	rest := tok.next
+	if m.handler != nil {
+		hs = tok.hideset.union(&hideset{name: m.name})
+		body = m.handler(tok)
+		body.origin = tok
+	} else if !m.funcLike {
+		hs = tok.hideset.union(&hideset{name: m.name})
+		body = pp.subst(m, nil, tok)
+	} else {
+		// A function-like macro name not followed by '(' is not an
+		// invocation.
+		if rest.kind != TK_RESERVED || rest.str != "(" {
+			return nil, false
+		}
+		args, rparen := readMacroArgs(m, tok, rest)
+		args = checkMacroArgs(m, tok, args, rparen)
+		hs = tok.hideset.intersect(rparen.hideset).union(&hideset{name: m.name})
+		body = pp.subst(m, args, tok)
+		rest = rparen.next
+	}
+
+	at := tok.expandedAt
+	if at == nil {
+		at = expansionRoot(tok)
+	}
+	if body == nil {
+		if rest.atBOL {
+			return rest, true
+		}
+		r := *rest
+		r.expandedAt = at
+		return &r, true
+	}
+	body.expandedAt = at
+	cur := body
+	for {
+		cur.hideset = cur.hideset.union(hs)
+		if cur.next == nil {
+			break
+		}
+		cur = cur.next
+	}
+	body.atBOL = tok.atBOL
+	body.hasSpace = tok.hasSpace
+	cur.next = rest
+	return body, true
+}
+
+// includeFilename reads the operand of the #include directive at tok
+// and returns the path of the file to include and the first token after
+// the directive.
+func (pp *preprocessor) includeFilename(tok *Token, directive *Token) (string, *Token) {
+	if tok.atBOL {
+		errorToken(directive, "#%s expects \"FILENAME\" or <FILENAME>", directive.str)
+	}
+
+	// #include "foo.h"
+	if tok.kind == TK_STRING {
+		name := tok.str[1 : len(tok.str)-1]
+		rest := pp.expectLineEnd(tok.next, directive.str)
+		return pp.searchInclude(name, tok, true, directive), rest
+	}
+
+	// #include <foo.h>
+	if tok.str == "<" {
+		name, rest := readBracketFilename(tok)
+		return pp.searchInclude(name, tok, false, directive), pp.expectLineEnd(rest, directive.str)
+	}
+
+	// #include FOO, where FOO expands to one of the forms above.
+	line, rest := copyLine(tok)
+	line = pp.preprocess(line)
+	if line.kind == TK_STRING {
+		name := line.str[1 : len(line.str)-1]
+		pp.expectLineEnd(line.next, directive.str)
+		return pp.searchInclude(name, tok, true, directive), rest
+	}
+	if line.str == "<" {
+		name, end := readBracketFilename(line)
+		pp.expectLineEnd(end, directive.str)
+		return pp.searchInclude(name, tok, false, directive), rest
+	}
+	errorToken(tok, "#%s expects \"FILENAME\" or <FILENAME>", directive.str)
+	return "", nil
+}
+
+// readBracketFilename reads a filename between '<' at tok and '>',
+// returning it and the token after the '>'.
+func readBracketFilename(tok *Token) (string, *Token) {
+	var b strings.Builder
+	for t := tok.next; !t.atBOL && t.kind != TK_EOF; t = t.next {
+		if t.str == ">" {
+			return b.String(), t.next
+		}
+		if t.hasSpace && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.str)
+	}
+	errorToken(tok, "missing terminating > character")
+	return "", nil
+}
+
+// searchInclude returns the path of the file included as name by the
+// #include or #include_next directive. A quoted name is looked for first
+// in the directory of the including file. #include_next continues the
+// search after the directory in which the including file was found.
+func (pp *preprocessor) searchInclude(name string, tok *Token, quoted bool, directive *Token) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	dirs := append(append([]string{}, pp.includePaths...), builtinIncludeDir)
+	start := 0
+	if directive.str == "include_next" {
+		if i, ok := pp.foundIn[directive.file.Name]; ok {
+			start = i + 1
+		}
+	} else if quoted {
+		path := filepath.Join(filepath.Dir(tok.file.Name), name)
+		if fileExists(path) {
+			return path
+		}
+	}
+	for i := start; i < len(dirs); i++ {
+		path := filepath.Join(dirs[i], name)
+		if fileExists(path) {
+			pp.foundIn[path] = i
+			return path
+		}
+	}
+	errorToken(tok, "%s: No such file or directory", name)
+	return ""
+}
+
+// includeFile returns the preprocessed tokens of the file at path,
+// without the end-of-input token.
+func (pp *preprocessor) includeFile(path string, directive *Token) *Token {
+	if pp.onceFiles[filepath.Clean(path)] {
+		return nil
+	}
+	if pp.includeDepth >= maxIncludeDepth {
+		errorToken(directive, "#include nested depth %d exceeds maximum of %d", pp.includeDepth+1, maxIncludeDepth)
+	}
+	contents, err := readFile(path)
+	if err != nil {
+		errorToken(directive, "%s: %v", path, err)
+	}
+	// The bundled headers are not files a build could depend on.
+	if _, ok := builtinPath(path); !ok && pp.included != nil {
+		pp.included(path)
+	}
+
+	pp.includeDepth++
+	defer func() { pp.includeDepth-- }()
+	tok := pp.preprocessFile(&File{Name: path, Contents: string(contents)})
+
+	head := &Token{next: tok}
+	cur := head
+	for cur.next.kind != TK_EOF {
+		cur = cur.next
+	}
+	cur.next = nil
+	return head.next
+}
+
+// printTokens writes the preprocessed tokens tok to w as text. A line
+// marker such as
+//
+//	# 12 "foo.h"
+//
+// precedes the tokens whenever they do not simply continue the lines
+// before them.
+func printTokens(w io.Writer, tok *Token) error {
+	b := bufio.NewWriter(w)
+	curName := ""
+	curLine := 0
+	first := true
+	var prev *Token
+	for ; tok.kind != TK_EOF; tok = tok.next {
+		// A macro expansion is on the line where it began, even if its
+		// first token came from an argument on a later line, and the rest
+		// of it follows on the same line.
+		root := tok.expandedAt
+		if root == nil {
+			root = expansionRoot(tok)
+		}
+		name, line := root.file.presumed(root.pos, root.line)
+		if tok.hideset != nil && tok.expandedAt == nil && !first {
+			name, line = curName, curLine
+		}
+		startsLine := true
+		switch {
+		case first || name != curName || line < curLine || line > curLine+8:
+			if !first {
+				b.WriteByte('\n')
+			}
+			fmt.Fprintf(b, "# %d %s\n", line, quote(name))
+		case line > curLine:
+			b.WriteString(strings.Repeat("\n", line-curLine))
+		default:
+			startsLine = false
+		}
+		first = false
+		curName, curLine = name, line
+
+		if startsLine {
+			b.WriteString(strings.Repeat(" ", root.col-1))
+		} else if tok.hasSpace || tok.atBOL || wouldPaste(prev, tok) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok.str)
+		prev = tok
+	}
+	if !first {
+		b.WriteByte('\n')
+	}
+	return b.Flush()
+}
+
+// wouldPaste reports whether printing tok right after prev would make
+// them read back as different tokens, like "-" "-1" or "x" "1".
+func wouldPaste(prev *Token, tok *Token) bool {
+	if prev.str == "" || tok.str == "" {
+		return false
+	}
+	s := prev.str + tok.str
+	t := tokenize(&File{Contents: s}, &diagnostics{})
+	if t.len != len(prev.str) || t.next.len != len(tok.str) {
+		return true
+	}
+	// ".." ".", for example
+	return prev.str == "." && tok.str == "."
+}
@@ -0,0 +1,721 @@
+package compiler
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type TokenKind int
+
+const (
+	TK_RESERVED TokenKind = iota
+	TK_IDENT
+	TK_NUM
+	TK_STRING
+	TK_EOF
+)
+
+// File is a source file.
+type File struct {
+	Name     string
+	Contents string
+
+	// markers are the #line directives in the file, in order.
+	markers []lineMarker
+}
+
+// lineMarker records a #line directive: the line starting at the byte
+// offset pos, which is physical line physLine of the file, is numbered
+// line and belongs to the file name.
+type lineMarker struct {
+	pos      int
+	physLine int
+	line     int
+	name     string
+}
+
+// presumed returns the file name and line number of the byte offset
+// pos on physical line physLine of f, as set by #line.
+func (f *File) presumed(pos int, physLine int) (string, int) {
+	name, line := f.Name, physLine
+	for _, m := range f.markers {
+		if m.pos > pos {
+			break
+		}
+		name, line = m.name, m.line+physLine-m.physLine
+	}
+	return name, line
+}
+
+type Token struct {
+	next *Token
+	kind TokenKind
+	val  int
+	// Type of a number
+	ty       Type
+	str      string
+	len      int
+	contents string
+
+	// Source location
+	file *File
+	pos  int
+	line int
+	col  int
+
+	// Whether the token is the first on its line
+	atBOL bool
+	// Whether the token is preceded by whitespace
+	hasSpace bool
+
+	// Macros that must not be expanded at this token
+	hideset *hideset
+	// For a token produced by expanding a macro, the macro name in the
+	// invocation
+	origin *Token
+	// For the first token of the output of a macro expansion, or the
+	// token after an empty one, the source token where the expansion
+	// began; -E prints the token there
+	expandedAt *Token
+	// Diagnostics about the token from tokenize, not yet reported
+	diags []*Diagnostic
+}
+
+func NewToken(kind TokenKind, cur *Token, file *File, pos int, len int) *Token {
+	tok := &Token{
+		kind: kind,
+		str:  file.Contents[pos : pos+len],
+		len:  len,
+		file: file,
+		pos:  pos,
+	}
+	cur.next = tok
+	return tok
+}
+
+// Pos returns the source location of the token.
+func (t *Token) Pos() Position {
+	return Position{
+		Filename: t.file.Name,
+		Offset:   t.pos,
+		Line:     t.line,
+		Column:   t.col,
+	}
+}
+
+func isWhiteSpace(r rune) bool {
+	return r == ' ' || r == '\n' || r == '\t' || r == '\r' || r == '\v' || r == '\f'
+}
+
+func isLetter(r rune) bool {
+	return ('A' <= r && r <= 'Z') || ('a' <= r && r <= 'z') || r == '_'
+}
+
+func isDigit(r rune) bool {
+	return '0' <= r && r <= '9'
+}
+
+func isAlNum(r rune) bool {
+	return isDigit(r) || isLetter(r)
+}
+
+func (p *Parser) consume(op string) bool {
+	if p.token.kind != TK_RESERVED || p.token.str != op {
+		return false
+	}
+	p.token = p.token.next
+	return true
+}
+
+func (p *Parser) peek(s string) bool {
+	return p.token.str == s
+}
+
+func (p *Parser) consumeIdent() *Token {
+	if p.token.kind != TK_IDENT {
+		return nil
+	}
+	token := p.token
+	p.token = token.next
+	return token
+}
+
+func (p *Parser) expect(op string) {
+	if p.token.kind != TK_RESERVED || p.token.str != op {
+		errorToken(p.token, "expected '%s'", op)
+	}
+	p.token = p.token.next
+}
+
+func (p *Parser) expectNumber() int {
+	if p.token.kind != TK_NUM {
+		errorToken(p.token, "expected a number")
+	}
+	val := p.token.val
+	p.token = p.token.next
+	return val
+}
+
+func (p *Parser) expectIdent() string {
+	if p.token.kind != TK_IDENT {
+		errorToken(p.token, "expected an identifier")
+	}
+	s := p.token.str
+	p.token = p.token.next
+	return s
+}
+
+func (t *Token) AtEOF() bool {
+	return t.kind == TK_EOF
+}
+
+var keywords = map[string]bool{
+	"auto": true, "break": true, "case": true, "char": true,
+	"const": true, "continue": true, "default": true, "do": true,
+	"double": true, "else": true, "enum": true, "extern": true,
+	"float": true, "for": true, "goto": true, "if": true,
+	"inline": true, "int": true, "long": true, "register": true,
+	"restrict": true, "return": true, "short": true, "signed": true,
+	"sizeof": true, "static": true, "struct": true, "switch": true,
+	"typedef": true, "union": true, "unsigned": true, "void": true,
+	"volatile": true, "while": true, "_Alignas": true, "_Alignof": true,
+	"_Atomic": true, "_Bool": true, "_Complex": true, "_Generic": true,
+	"_Imaginary": true, "_Noreturn": true, "_Static_assert": true,
+	"_Thread_local": true,
+}
+
+// gnuKeywords maps the GNU keywords accepted by gcc, as used in the
+// system headers, to their spelling in the parser. The alternate
+// spellings of C keywords become the keywords themselves.
+var gnuKeywords = map[string]string{
+	"__attribute__": "__attribute__", "__attribute": "__attribute__",
+	"__asm__": "__asm__", "__asm": "__asm__",
+	"__extension__": "__extension__", "__builtin_va_list": "__builtin_va_list",
+	"__restrict": "restrict", "__restrict__": "restrict",
+	"__inline": "inline", "__inline__": "inline",
+	"__const": "const", "__const__": "const",
+	"__volatile": "volatile", "__volatile__": "volatile",
+	"__signed": "signed", "__signed__": "signed",
+	"__alignof": "_Alignof", "__alignof__": "_Alignof",
+}
+
+// punctuators maps each C11 punctuator to its spelling. Digraphs are
+// spelled as the punctuator they stand for.
+var punctuators = map[string]string{
+	"[": "[", "]": "]", "(": "(", ")": ")", "{": "{", "}": "}",
+	".": ".", "->": "->", "++": "++", "--": "--", "&": "&", "*": "*",
+	"+": "+", "-": "-", "~": "~", "!": "!", "/": "/", "%": "%",
+	"<<": "<<", ">>": ">>", "<": "<", ">": ">", "<=": "<=", ">=": ">=",
+	"==": "==", "!=": "!=", "^": "^", "|": "|", "&&": "&&", "||": "||",
+	"?": "?", ":": ":", ";": ";", "...": "...", "=": "=", "*=": "*=",
+	"/=": "/=", "%=": "%=", "+=": "+=", "-=": "-=", "<<=": "<<=",
+	">>=": ">>=", "&=": "&=", "^=": "^=", "|=": "|=", ",": ",",
+	"#": "#", "##": "##",
+	"<:": "[", ":>": "]", "<%": "{", "%>": "}", "%:": "#", "%:%:": "##",
+}
+
+// readPunct returns the length of the longest punctuator at the start
+// of s, or 0 if there is none.
+func readPunct(s string) int {
+	for n := 4; n > 0; n-- {
+		if n <= len(s) {
+			if _, ok := punctuators[s[:n]]; ok {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+func getEscapeChar(r rune) rune {
+	switch r {
+	case 'a':
+		return '\a'
+	case 'b':
+		return '\b'
+	case 't':
+		return '\t'
+	case 'n':
+		return '\n'
+	case 'v':
+		return '\v'
+	case 'f':
+		return '\f'
+	case 'r':
+		return '\r'
+	case 'e':
+		return 27
+	default:
+		return r
+	}
+}
+
+func isOctDigit(c byte) bool {
+	return '0' <= c && c <= '7'
+}
+
+func hexValue(c byte) (int, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0'), true
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10, true
+	case 'A' <= c && c <= 'F':
+		return int(c-'A') + 10, true
+	}
+	return 0, false
+}
+
+// readEscapedChar reads the escape sequence starting with the backslash
+// at pos in file, which must not be the last byte. It appends the bytes
+// it stands for to buf, and returns buf and the offset just past the
+// sequence. A universal character name is encoded in UTF-8.
+func readEscapedChar(file *File, pos int, diag *diagnostics, buf []byte) ([]byte, int) {
+	input := file.Contents
+	i := pos + 1
+
+	if isOctDigit(input[i]) {
+		val := 0
+		for n := 0; n < 3 && i < len(input) && isOctDigit(input[i]); n++ {
+			val = val*8 + int(input[i]-'0')
+			i++
+		}
+		if val > 0xff {
+			diag.add(newErrorRange(file, pos, i, "octal escape sequence out of range"))
+		}
+		return append(buf, byte(val)), i
+	}
+
+	if input[i] == 'x' {
+		i++
+		val := 0
+		digits := 0
+		for ; i < len(input); i++ {
+			d, ok := hexValue(input[i])
+			if !ok {
+				break
+			}
+			if val <= 0xff {
+				val = val*16 + d
+			}
+			digits++
+		}
+		if digits == 0 {
+			diag.add(newErrorRange(file, pos, i, "\\x used with no following hex digits"))
+		} else if val > 0xff {
+			diag.add(newErrorRange(file, pos, i, "hex escape sequence out of range"))
+		}
+		return append(buf, byte(val)), i
+	}
+
+	if input[i] == 'u' || input[i] == 'U' {
+		n := 4
+		if input[i] == 'U' {
+			n = 8
+		}
+		i++
+		val := 0
+		digits := 0
+		for ; digits < n && i < len(input); digits++ {
+			d, ok := hexValue(input[i])
+			if !ok {
+				break
+			}
+			val = val*16 + d
+			i++
+		}
+		if digits < n {
+			diag.add(newErrorRange(file, pos, i, "incomplete universal character name %s", input[pos:i]))
+			return buf, i
+		}
+		if !isValidUCN(val) {
+			diag.add(newErrorRange(file, pos, i, "%s is not a valid universal character", input[pos:i]))
+			return buf, i
+		}
+		return utf8.AppendRune(buf, rune(val)), i
+	}
+
+	return append(buf, byte(getEscapeChar(rune(input[i])))), i + 1
+}
+
+// isValidUCN reports whether c may be written as a universal character
+// name (C11 6.4.3).
+func isValidUCN(c int) bool {
+	if c < 0xa0 {
+		return c == '$' || c == '@' || c == '`'
+	}
+	return c <= utf8.MaxRune && !(0xd800 <= c && c <= 0xdfff)
+}
+
+// readCharLiteral reads the character constant starting at pos in file,
+// at the quote or at an L, u or U prefix. A plain constant has type int;
+// one with several characters has the value gcc gives it. A prefixed
+// constant holds a single character of the wider type.
+func readCharLiteral(cur *Token, file *File, pos int, diag *diagnostics) *Token {
+	input := file.Contents
+	chars := []byte{}
+	prefix := byte(0)
+	i := pos + 1
+	if input[pos] != '\'' {
+		prefix = input[pos]
+		i++
+	}
+	for {
+		if i >= len(input) || input[i] == '\n' {
+			// Treat the rest of the line as the literal, whose value
+			// does not matter.
+			diag.add(newErrorRange(file, pos, i, "missing terminating ' character"))
+			tok := NewToken(TK_NUM, cur, file, pos, i-pos)
+			tok.ty = intType
+			return tok
+		}
+		if input[i] == '\'' {
+			i++
+			break
+		}
+		if input[i] == '\\' && i+1 < len(input) {
+			chars, i = readEscapedChar(file, i, diag, chars)
+		} else {
+			chars = append(chars, input[i])
+			i++
+		}
+	}
+
+	tok := NewToken(TK_NUM, cur, file, pos, i-pos)
+	tok.ty = intType
+	switch {
+	case len(chars) == 0:
+		diag.add(tokenError(tok, "empty character constant"))
+	case prefix != 0:
+		c, size := utf8.DecodeRune(chars)
+		if c == utf8.RuneError && size == 1 {
+			c = rune(chars[0])
+		}
+		if size < len(chars) {
+			diag.add(tokenError(tok, "character constant too long for its type"))
+		}
+		switch prefix {
+		case 'L':
+			tok.val = int(int32(c))
+		case 'u':
+			tok.val = int(uint16(c))
+		default:
+			tok.val = int(uint32(c))
+		}
+	case len(chars) == 1:
+		// char is signed.
+		tok.val = int(int8(chars[0]))
+	case len(chars) > 4:
+		diag.add(tokenError(tok, "character constant too long for its type"))
+	default:
+		for _, c := range chars {
+			tok.val = tok.val<<8 | int(c)
+		}
+		tok.val = int(int32(tok.val))
+		diag.warn(tok, WarnMultichar, "multi-character character constant")
+	}
+	return tok
+}
+
+func readStringLiteral(cur *Token, file *File, pos int, diag *diagnostics) *Token {
+	input := file.Contents
+	str := []byte{}
+	i := pos + 1
+	for {
+		if i >= len(input) || input[i] == '\n' {
+			// Treat the rest of the line as the literal and continue.
+			diag.add(newErrorRange(file, pos, i, "unclosed string literal"))
+			tok := NewToken(TK_STRING, cur, file, pos, i-pos)
+			tok.contents = string(str)
+			return tok
+		}
+
+		if input[i] == '"' {
+			break
+		}
+
+		if input[i] == '\\' && i+1 < len(input) {
+			str, i = readEscapedChar(file, i, diag, str)
+		} else {
+			str = append(str, input[i])
+			i++
+		}
+	}
+
+	tok := NewToken(TK_STRING, cur, file, pos, i+1-pos)
+	tok.contents = string(str)
+	return tok
+}
+
+// joinAdjacentStrings concatenates each run of adjacent string literals
+// into the first of them.
+func joinAdjacentStrings(tok *Token) {
+	for ; tok != nil; tok = tok.next {
+		if tok.kind != TK_STRING {
+			continue
+		}
+		for tok.next != nil && tok.next.kind == TK_STRING {
+			next := tok.next
+			tok.contents += next.contents
+			if next.file == tok.file {
+				tok.len = next.pos + next.len - tok.pos
+			}
+			tok.next = next.next
+		}
+	}
+}
+
+// integerSuffixes is the set of valid integer constant suffixes.
+var integerSuffixes = map[string]bool{}
+
+func init() {
+	for _, u := range []string{"", "u", "U"} {
+		for _, l := range []string{"", "l", "L", "ll", "LL"} {
+			integerSuffixes[u+l] = true
+			integerSuffixes[l+u] = true
+		}
+	}
+}
+
+// readNumber reads the preprocessing number starting at pos in file. It
+// extends over all following letters, digits and dots, so that a
+// malformed constant is reported as a whole by convertNumber.
+func readNumber(cur *Token, file *File, pos int) *Token {
+	input := file.Contents
+	i := pos
+	for i < len(input) && (isAlNum(rune(input[i])) || input[i] == '.') {
+		i++
+	}
+	return NewToken(TK_NUM, cur, file, pos, i-pos)
+}
+
+// convertNumber sets the value of the integer constant tok from its
+// spelling.
+func convertNumber(tok *Token, diag *diagnostics) {
+	s := tok.str
+	base := 10
+	switch {
+	case len(s) > 2 && (s[:2] == "0x" || s[:2] == "0X"):
+		base = 16
+		s = s[2:]
+	case len(s) > 2 && (s[:2] == "0b" || s[:2] == "0B"):
+		base = 2
+		s = s[2:]
+	case s[0] == '0':
+		base = 8
+	}
+
+	n := 0
+	for ; n < len(s); n++ {
+		d, ok := hexValue(s[n])
+		if !ok || (base != 16 && d >= 10) {
+			break
+		}
+	}
+	digits, suffix := s[:n], s[n:]
+	if digits == "" {
+		// Such as "0x" or "0b": the 0 is the number and the rest its suffix.
+		suffix = tok.str[1:]
+	}
+
+	if strings.ContainsRune(tok.str, '.') || (base == 10 && len(suffix) > 0 && (suffix[0] == 'e' || suffix[0] == 'E')) {
+		diag.add(tokenError(tok, "floating constants are not supported"))
+		return
+	}
+	if digits == "" || !integerSuffixes[suffix] {
+		diag.add(tokenError(tok, "invalid suffix \"%s\" on integer constant", suffix))
+		return
+	}
+	for j := 0; j < len(digits); j++ {
+		if d, _ := hexValue(digits[j]); d >= base {
+			diag.add(tokenError(tok, "invalid digit \"%c\" in %s constant", digits[j], baseNames[base]))
+			return
+		}
+	}
+
+	val, err := strconv.ParseUint(digits, base, 64)
+	unsigned := strings.ContainsAny(suffix, "uU")
+	// A decimal constant without a u suffix must fit a signed type; others
+	// can be unsigned long long.
+	if err != nil || (base == 10 && !unsigned && val > math.MaxInt64) {
+		diag.add(tokenError(tok, "integer constant is too large for its type"))
+		return
+	}
+	tok.val = int(val)
+	// The constant has the first type that can represent it: int, then
+	// long. An l or ll suffix skips int, a u suffix selects the unsigned
+	// types, and a constant that is not decimal can also be unsigned
+	// without one.
+	long := strings.ContainsAny(suffix, "lL")
+	decimal := base == 10
+	switch {
+	case !long && !unsigned && val <= math.MaxInt32:
+		tok.ty = intType
+	case !long && (unsigned || !decimal) && val <= math.MaxUint32:
+		tok.ty = uintType
+	case !unsigned && val <= math.MaxInt64:
+		tok.ty = longType
+	default:
+		tok.ty = ulongType
+	}
+}
+
+var baseNames = map[int]string{2: "binary", 8: "octal", 10: "decimal", 16: "hexadecimal"}
+
+// isCharLiteral reports whether the TK_NUM token tok is a character
+// constant rather than a number.
+func isCharLiteral(tok *Token) bool {
+	return strings.ContainsRune(tok.str, '\'')
+}
+
+// convertTokens turns preprocessing tokens into tokens: identifiers that
+// are keywords become reserved words and numbers get their values.
+func convertTokens(tok *Token, diag *diagnostics) {
+	for ; tok != nil; tok = tok.next {
+		switch {
+		case tok.kind == TK_IDENT && keywords[tok.str]:
+			tok.kind = TK_RESERVED
+		case tok.kind == TK_IDENT && gnuKeywords[tok.str] != "":
+			tok.kind = TK_RESERVED
+			tok.str = gnuKeywords[tok.str]
+		case tok.kind == TK_NUM && !isCharLiteral(tok):
+			convertNumber(tok, diag)
+		}
+	}
+}
+
+// addLineNumbers fills in the line and column of each token.
+func addLineNumbers(tok *Token) {
+	input := tok.file.Contents
+	line := 1
+	lineStart := 0
+	i := 0
+	for ; tok != nil; tok = tok.next {
+		for ; i < tok.pos; i++ {
+			if input[i] == '\n' {
+				line++
+				lineStart = i + 1
+			}
+		}
+		tok.line = line
+		tok.col = tok.pos - lineStart + 1
+	}
+}
+
+// Tokenize splits file into tokens. Errors are recorded in diag and the
+// offending characters are skipped.
+func Tokenize(file *File, diag *diagnostics) *Token {
+	tok := tokenize(file, diag)
+	for t := tok; t != nil; t = t.next {
+		reportLexical(t, diag)
+	}
+	return tok
+}
+
+// reportLexical reports the diagnostics attached to tok by tokenize.
+func reportLexical(tok *Token, diag *diagnostics) {
+	for _, d := range tok.diags {
+		diag.add(d)
+	}
+	tok.diags = nil
+}
+
+// tokenize is Tokenize, except that the diagnostics about each token,
+// or about invalid characters before it, are attached to the token
+// instead of being reported. The preprocessor reports them unless the
+// token is in a skipped group.
+func tokenize(file *File, diag *diagnostics) *Token {
+	lex := &diagnostics{warnings: diag.warnings, werror: diag.werror}
+	input := file.Contents
+	head := &Token{}
+	cur := head
+	atBOL := true
+	hasSpace := false
+	i := 0
+	for i < len(input) {
+		if input[i] == '\n' {
+			i++
+			atBOL = true
+			hasSpace = false
+			continue
+		}
+		// A backslash-newline joins two lines.
+		if strings.HasPrefix(input[i:], "\\\n") {
+			i += 2
+			hasSpace = true
+			continue
+		}
+		if isWhiteSpace(rune(input[i])) {
+			i++
+			hasSpace = true
+			continue
+		}
+		if strings.HasPrefix(input[i:], "//") {
+			i += 2
+			for i < len(input) && input[i] != '\n' {
+				i++
+			}
+			hasSpace = true
+			continue
+		}
+		if strings.HasPrefix(input[i:], "/*") {
+			index := strings.Index(input[i+2:], "*/")
+			if index == -1 {
+				diag.add(newError(file, i, "unclosed block comment"))
+				i = len(input)
+				continue
+			}
+			i += index + 4
+			hasSpace = true
+			continue
+		}
+
+		prev := cur
+		switch {
+		case strings.ContainsRune("LuU", rune(input[i])) && strings.HasPrefix(input[i+1:], "'"):
+			cur = readCharLiteral(cur, file, i, lex)
+			i += cur.len
+		case isLetter(rune(input[i])):
+			pos := i
+			i++
+			for ; i < len(input) && isAlNum(rune(input[i])); i++ {
+			}
+			cur = NewToken(TK_IDENT, cur, file, pos, i-pos)
+		case isDigit(rune(input[i])):
+			cur = readNumber(cur, file, i)
+			i += cur.len
+		case input[i] == '"':
+			cur = readStringLiteral(cur, file, i, lex)
+			i += cur.len
+		case input[i] == '\'':
+			cur = readCharLiteral(cur, file, i, lex)
+			i += cur.len
+		default:
+			n := readPunct(input[i:])
+			if n == 0 {
+				lex.add(newError(file, i, "invalid token"))
+				i++
+				continue
+			}
+			cur = NewToken(TK_RESERVED, cur, file, i, n)
+			cur.str = punctuators[cur.str]
+			i += n
+		}
+		if cur != prev {
+			cur.atBOL = atBOL
+			cur.hasSpace = hasSpace
+			cur.diags = lex.list
+			lex.list = nil
+			atBOL = false
+			hasSpace = false
+		}
+	}
+
+	eof := NewToken(TK_EOF, cur, file, i, 0)
+	eof.atBOL = true
+	eof.diags = lex.list
+	addLineNumbers(head.next)
+	return head.next
+}
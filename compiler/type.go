@@ -0,0 +1,470 @@
+package compiler
+
+type TypeKind int
+
+type Type interface {
+	size() int
+	// align returns the alignment of the type in bytes, which the
+	// address of an object of the type is a multiple of.
+	align() int
+}
+
+// BoolType is _Bool, whose values are 0 and 1.
+type BoolType struct{}
+
+func NewBoolType() *BoolType {
+	return &BoolType{}
+}
+
+func (b *BoolType) size() int {
+	return 1
+}
+
+func (b *BoolType) align() int {
+	return 1
+}
+
+// CharType is char, which is signed, and its signed and unsigned
+// variants.
+type CharType struct {
+	unsigned bool
+}
+
+func NewCharType(unsigned bool) *CharType {
+	return &CharType{unsigned: unsigned}
+}
+
+func (c *CharType) size() int {
+	return 1
+}
+
+func (c *CharType) align() int {
+	return 1
+}
+
+type ShortType struct {
+	unsigned bool
+}
+
+func NewShortType(unsigned bool) *ShortType {
+	return &ShortType{unsigned: unsigned}
+}
+
+func (s *ShortType) size() int {
+	return 2
+}
+
+func (s *ShortType) align() int {
+	return 2
+}
+
+type IntType struct {
+	unsigned bool
+}
+
+func NewIntType(unsigned bool) *IntType {
+	return &IntType{unsigned: unsigned}
+}
+
+func (i *IntType) size() int {
+	return 4
+}
+
+func (i *IntType) align() int {
+	return 4
+}
+
+// LongType is long, and also long long, which has the same size.
+type LongType struct {
+	unsigned bool
+}
+
+func NewLongType(unsigned bool) *LongType {
+	return &LongType{unsigned: unsigned}
+}
+
+func (l *LongType) size() int {
+	return 8
+}
+
+func (l *LongType) align() int {
+	return 8
+}
+
+type PointerType struct {
+	Type
+	base Type
+}
+
+func NewPointerType(base Type) *PointerType {
+	return &PointerType{
+		base: base,
+	}
+}
+
+func (p *PointerType) size() int {
+	return 8
+}
+
+func (p *PointerType) align() int {
+	return 8
+}
+
+var boolType Type = NewBoolType()
+var charType Type = NewCharType(false)
+var shortType Type = NewShortType(false)
+var intType Type = NewIntType(false)
+var longType Type = NewLongType(false)
+var ucharType Type = NewCharType(true)
+var ushortType Type = NewShortType(true)
+var uintType Type = NewIntType(true)
+var ulongType Type = NewLongType(true)
+var voidType Type = NewVoidType()
+
+// VoidType is void, which has no values. Like gcc, it has size 1 so
+// that sizeof(void) is 1.
+type VoidType struct{}
+
+func NewVoidType() *VoidType {
+	return &VoidType{}
+}
+
+func (v *VoidType) size() int {
+	return 1
+}
+
+func (v *VoidType) align() int {
+	return 1
+}
+
+// Param is a parameter of a function type. name is nil if the
+// parameter is unnamed.
+type Param struct {
+	name *Token
+	ty   Type
+}
+
+// FuncType is the type of a function.
+type FuncType struct {
+	ret    Type
+	params []*Param
+	// Whether the parameter list ends with "..."
+	variadic bool
+}
+
+func NewFuncType(ret Type, params []*Param, variadic bool) *FuncType {
+	return &FuncType{
+		ret:      ret,
+		params:   params,
+		variadic: variadic,
+	}
+}
+
+// size returns 1, the value gcc gives to sizeof applied to a function.
+func (f *FuncType) size() int {
+	return 1
+}
+
+func (f *FuncType) align() int {
+	return 1
+}
+
+type ArrayType struct {
+	Type
+	base Type
+	len  int
+}
+
+func NewArrayType(base Type, len int) *ArrayType {
+	return &ArrayType{
+		base: base,
+		len:  len,
+	}
+}
+
+func (a *ArrayType) size() int {
+	return a.base.size() * a.len
+}
+
+func (a *ArrayType) align() int {
+	return a.base.align()
+}
+
+type Struct struct {
+	Type
+	// tag is the name of the structure, or nil if it has none.
+	tag       *Token
+	members   []*Member
+	byteSize  int
+	alignment int
+	// isIncomplete is set until the members of a structure that has
+	// only been declared by its tag are known.
+	isIncomplete bool
+}
+
+// NewStructType returns a structure of members laid out as the System V
+// x86-64 ABI requires.
+func NewStructType(members []*Member) *Struct {
+	s := &Struct{}
+	s.setMembers(members)
+	return s
+}
+
+// newIncompleteStruct returns the structure named tag, whose members are
+// not known yet.
+func newIncompleteStruct(tag *Token) *Struct {
+	return &Struct{
+		tag:          tag,
+		alignment:    1,
+		isIncomplete: true,
+	}
+}
+
+// setMembers completes s with members: each member is at the next offset
+// that is a multiple of its alignment, and the size is padded to a
+// multiple of the alignment of the structure, the largest alignment of a
+// member.
+func (s *Struct) setMembers(members []*Member) {
+	offset := 0
+	alignment := 1
+	for _, m := range members {
+		a := m.ty.align()
+		if m.align > a {
+			a = m.align
+		}
+		offset = alignTo(offset, a)
+		m.offset = offset
+		offset += m.ty.size()
+		if a > alignment {
+			alignment = a
+		}
+	}
+	s.members = members
+	s.byteSize = alignTo(offset, alignment)
+	s.alignment = alignment
+	s.isIncomplete = false
+}
+
+func (s *Struct) size() int {
+	return s.byteSize
+}
+
+func (s *Struct) align() int {
+	return s.alignment
+}
+
+func (s *Struct) FindMember(name string) *Member {
+	for i := range s.members {
+		if name == s.members[i].name {
+			return s.members[i]
+		}
+	}
+	return nil
+}
+
+// UnionType is a union, whose members all start at offset 0.
+type UnionType struct {
+	// tag is the name of the union, or nil if it has none.
+	tag          *Token
+	members      []*Member
+	byteSize     int
+	alignment    int
+	isIncomplete bool
+}
+
+func NewUnionType(members []*Member) *UnionType {
+	u := &UnionType{}
+	u.setMembers(members)
+	return u
+}
+
+// newIncompleteUnion returns the union named tag, whose members are not
+// known yet.
+func newIncompleteUnion(tag *Token) *UnionType {
+	return &UnionType{
+		tag:          tag,
+		alignment:    1,
+		isIncomplete: true,
+	}
+}
+
+// setMembers completes u with members. The size and alignment are those
+// of the largest member, with the size padded to a multiple of the
+// alignment.
+func (u *UnionType) setMembers(members []*Member) {
+	size := 0
+	alignment := 1
+	for _, m := range members {
+		m.offset = 0
+		a := m.ty.align()
+		if m.align > a {
+			a = m.align
+		}
+		if m.ty.size() > size {
+			size = m.ty.size()
+		}
+		if a > alignment {
+			alignment = a
+		}
+	}
+	u.members = members
+	u.byteSize = alignTo(size, alignment)
+	u.alignment = alignment
+	u.isIncomplete = false
+}
+
+func (u *UnionType) size() int {
+	return u.byteSize
+}
+
+func (u *UnionType) align() int {
+	return u.alignment
+}
+
+func (u *UnionType) FindMember(name string) *Member {
+	for i := range u.members {
+		if name == u.members[i].name {
+			return u.members[i]
+		}
+	}
+	return nil
+}
+
+// recordType is a structure or a union: a type with members, which can be
+// declared by its tag before the members are known.
+type recordType interface {
+	Type
+	FindMember(name string) *Member
+	setMembers(members []*Member)
+}
+
+// vaListType is __builtin_va_list, the va_list of the System V x86-64
+// ABI: an array of one structure holding the state of the arguments.
+var vaListType Type = NewArrayType(NewStructType([]*Member{
+	{name: "gp_offset", ty: intType},
+	{name: "fp_offset", ty: intType},
+	{name: "overflow_arg_area", ty: NewPointerType(charType)},
+	{name: "reg_save_area", ty: NewPointerType(charType)},
+}), 1)
+
+// isUnsigned reports whether ty is an unsigned integer type or a
+// pointer, which holds an unsigned address.
+func isUnsigned(ty Type) bool {
+	switch t := ty.(type) {
+	case *BoolType:
+		return true
+	case *CharType:
+		return t.unsigned
+	case *ShortType:
+		return t.unsigned
+	case *IntType:
+		return t.unsigned
+	case *LongType:
+		return t.unsigned
+	case *PointerType, *ArrayType:
+		return true
+	}
+	return false
+}
+
+// is64 reports whether values of type ty take all 64 bits of a
+// register. Arrays are converted to pointers.
+func is64(ty Type) bool {
+	switch ty.(type) {
+	case *LongType, *PointerType, *ArrayType:
+		return true
+	}
+	return false
+}
+
+// promote returns the type of ty after the integer promotions, which
+// convert the types narrower than int to int.
+func promote(ty Type) Type {
+	switch ty.(type) {
+	case *BoolType, *CharType, *ShortType:
+		return intType
+	}
+	return ty
+}
+
+// commonType returns the type that the usual arithmetic conversions
+// give operands of types a and b: the wider of the promoted types, which
+// is unsigned if either of them is unsigned and both have the same size.
+// The result for a pointer operand is the pointer type.
+func commonType(a Type, b Type) Type {
+	a, b = promote(a), promote(b)
+	switch {
+	case is64(a) && !is64(b):
+		return a
+	case is64(b) && !is64(a):
+		return b
+	case isUnsigned(a):
+		return a
+	case isUnsigned(b):
+		return b
+	}
+	return a
+}
+
+// isInteger reports whether ty is an integer type.
+func isInteger(ty Type) bool {
+	switch ty.(type) {
+	case *BoolType, *CharType, *ShortType, *IntType, *LongType:
+		return true
+	}
+	return false
+}
+
+// pointerBase returns the type that ty points to if ty is a pointer or
+// an array, which is converted to a pointer to its first element, and nil
+// otherwise.
+func pointerBase(ty Type) Type {
+	switch t := ty.(type) {
+	case *PointerType:
+		return t.base
+	case *ArrayType:
+		return t.base
+	}
+	return nil
+}
+
+// alignTo rounds n up to a multiple of align.
+func alignTo(n int, align int) int {
+	return (n + align - 1) / align * align
+}
+
+// isIncomplete reports whether ty is a structure or a union whose
+// members are not known, so that no object of the type can be created.
+func isIncomplete(ty Type) bool {
+	switch t := ty.(type) {
+	case *Struct:
+		return t.isIncomplete
+	case *UnionType:
+		return t.isIncomplete
+	}
+	return false
+}
+
+// typeString returns the name of ty for an error message. Only
+// structures and unions with a tag have a name.
+func typeString(ty Type) string {
+	switch t := ty.(type) {
+	case *Struct:
+		if t.tag != nil {
+			return "struct " + t.tag.str
+		}
+		return "struct"
+	case *UnionType:
+		if t.tag != nil {
+			return "union " + t.tag.str
+		}
+		return "union"
+	}
+	return ""
+}
+
+// isRecord reports whether ty is a structure or a union.
+func isRecord(ty Type) bool {
+	_, ok := ty.(recordType)
+	return ok
+}
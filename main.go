@@ -1,40 +1,496 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gocc/compiler"
 )
 
-func main() {
-	if len(os.Args) < 2 {
-		fmt.Printf("%v: invalid number of arguments", os.Args[0])
+type options struct {
+	// -o
+	output string
+	// -S: stop after generating assembly
+	asmOnly bool
+	// -c: stop after assembling
+	objOnly bool
+	// -E: stop after preprocessing
+	preprocessOnly bool
+	// -M: print dependencies instead of preprocessing
+	depOnly bool
+	// -MD: write dependencies while compiling
+	writeDeps bool
+	// -MF
+	depOutput string
+	// -l, -L and -Wl, passed through to the linker
+	linkArgs []string
+	// -I
+	includePaths []string
+	// -D and -U, in order
+	macros []compiler.MacroDef
+	// -fmax-errors
+	maxErrors int
+	// -W<name>, -Wno-<name>, -Wall and -Wextra
+	warnings map[string]bool
+	// -Werror
+	werror bool
+	// -fdiagnostics-format: "text", "json" or "sarif"
+	diagFormat string
+
+	// Diagnostics reported so far, if they are printed at the end
+	diagnostics []*compiler.Diagnostic
+	// Files included by the current input
+	included []string
+
+	inputs []string
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [-E | -S | -c] [-M | -MD] [-MF <file>] [-I <dir>] [-D <macro>[=<val>]] [-U <macro>] [-o <file>] <file>...\n", os.Args[0])
+	os.Exit(1)
+}
+
+// warningGroups lists the warnings enabled by -Wall and -Wextra.
+var warningGroups = map[string][]string{
+	"all": {
+		compiler.WarnUnusedVariable,
+		compiler.WarnReturnType,
+		compiler.WarnUnreachableCode,
+	},
+	"extra": {
+		compiler.WarnUnusedParameter,
+	},
+}
+
+func isKnownWarning(name string) bool {
+	for _, w := range compiler.KnownWarnings {
+		if w == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWarningFlag handles a -W option other than -Werror.
+func (opts *options) parseWarningFlag(arg string) error {
+	name := arg[2:]
+	on := true
+	if strings.HasPrefix(name, "no-") {
+		name = name[3:]
+		on = false
+	}
+	if group, ok := warningGroups[name]; ok {
+		for _, w := range group {
+			opts.warnings[w] = on
+		}
+		return nil
+	}
+	if !isKnownWarning(name) {
+		return fmt.Errorf("unknown warning option: %s", arg)
+	}
+	opts.warnings[name] = on
+	return nil
+}
+
+// parseMacroDef parses a -DNAME, -DNAME=VALUE or -UNAME option.
+func parseMacroDef(arg string) compiler.MacroDef {
+	if arg[1] == 'U' {
+		return compiler.MacroDef{Name: arg[2:], Undef: true}
+	}
+	if i := strings.IndexByte(arg, '='); i >= 0 {
+		return compiler.MacroDef{Name: arg[2:i], Value: arg[i+1:]}
+	}
+	return compiler.MacroDef{Name: arg[2:], Value: "1"}
+}
+
+func parseArgs(args []string) (*options, error) {
+	opts := &options{
+		warnings:   map[string]bool{},
+		diagFormat: "text",
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-o":
+			if i+1 == len(args) {
+				return nil, errors.New("missing filename after '-o'")
+			}
+			i++
+			opts.output = args[i]
+		case strings.HasPrefix(arg, "-o"):
+			opts.output = arg[2:]
+		case arg == "-S":
+			opts.asmOnly = true
+		case arg == "-c":
+			opts.objOnly = true
+		case arg == "-E":
+			opts.preprocessOnly = true
+		case arg == "-M":
+			opts.depOnly = true
+		case arg == "-MD":
+			opts.writeDeps = true
+		case arg == "-MF":
+			if i+1 == len(args) {
+				return nil, errors.New("missing filename after '-MF'")
+			}
+			i++
+			opts.depOutput = args[i]
+		case strings.HasPrefix(arg, "-MF"):
+			opts.depOutput = arg[3:]
+		case arg == "-l" || arg == "-L":
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("missing argument after '%s'", arg)
+			}
+			i++
+			opts.linkArgs = append(opts.linkArgs, arg+args[i])
+		case strings.HasPrefix(arg, "-l") || strings.HasPrefix(arg, "-L"):
+			opts.linkArgs = append(opts.linkArgs, arg)
+		case arg == "-I":
+			if i+1 == len(args) {
+				return nil, errors.New("missing argument after '-I'")
+			}
+			i++
+			opts.includePaths = append(opts.includePaths, args[i])
+		case strings.HasPrefix(arg, "-I"):
+			opts.includePaths = append(opts.includePaths, arg[2:])
+		case arg == "-D" || arg == "-U":
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("macro name missing after '%s'", arg)
+			}
+			i++
+			opts.macros = append(opts.macros, parseMacroDef(arg+args[i]))
+		case strings.HasPrefix(arg, "-D") || strings.HasPrefix(arg, "-U"):
+			opts.macros = append(opts.macros, parseMacroDef(arg))
+		case strings.HasPrefix(arg, "-fmax-errors="):
+			n, err := strconv.Atoi(arg[len("-fmax-errors="):])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid argument: %s", arg)
+			}
+			opts.maxErrors = n
+		case strings.HasPrefix(arg, "-fdiagnostics-format="):
+			opts.diagFormat = arg[len("-fdiagnostics-format="):]
+			switch opts.diagFormat {
+			case "text", "json", "sarif":
+			default:
+				return nil, fmt.Errorf("invalid argument: %s", arg)
+			}
+		case arg == "-Werror":
+			opts.werror = true
+		case arg == "-Wno-error":
+			opts.werror = false
+		case arg == "-w":
+			for _, w := range compiler.KnownWarnings {
+				opts.warnings[w] = false
+			}
+		case strings.HasPrefix(arg, "-Wl,"):
+			opts.linkArgs = append(opts.linkArgs, arg)
+		case strings.HasPrefix(arg, "-W"):
+			if err := opts.parseWarningFlag(arg); err != nil {
+				return nil, err
+			}
+		case arg == "--help":
+			usage()
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return nil, fmt.Errorf("unknown argument: %s", arg)
+		default:
+			opts.inputs = append(opts.inputs, arg)
+		}
+	}
+
+	if len(opts.inputs) == 0 {
+		return nil, errors.New("no input files")
+	}
+	if len(opts.inputs) > 1 && opts.output != "" && (opts.asmOnly || opts.objOnly) && !opts.preprocessOnly && !opts.depOnly {
+		return nil, errors.New("cannot specify '-o' with '-c' or '-S' with multiple files")
+	}
+	return opts, nil
+}
+
+// replaceExt returns the base name of path with its extension
+// replaced, which is where cc puts per-file outputs by default.
+func replaceExt(path string, ext string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base)) + ext
+}
+
+func runCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v", name, err)
+	}
+	return nil
+}
+
+func assemble(input string, output string) error {
+	return runCommand("as", "-o", output, input)
+}
+
+func link(inputs []string, output string, linkArgs []string) error {
+	args := []string{"-static", "-o", output}
+	args = append(args, inputs...)
+	args = append(args, linkArgs...)
+	return runCommand("gcc", args...)
+}
+
+// report prints a text diagnostic immediately, and saves one in other
+// formats to be printed by printDiagnostics.
+func (opts *options) report(d *compiler.Diagnostic) {
+	if opts.diagFormat == "text" {
+		fmt.Fprint(os.Stderr, d.Render())
 		return
 	}
-	bytes, err := os.ReadFile(os.Args[1])
+	opts.diagnostics = append(opts.diagnostics, d)
+}
+
+// printDiagnostics prints the diagnostics saved by report.
+func (opts *options) printDiagnostics() error {
+	switch opts.diagFormat {
+	case "json":
+		return compiler.WriteJSON(os.Stderr, opts.diagnostics)
+	case "sarif":
+		return compiler.WriteSARIF(os.Stderr, opts.diagnostics)
+	}
+	return nil
+}
+
+// readInput returns the contents of input, which is the standard input
+// for "-".
+func readInput(input string) ([]byte, error) {
+	if input == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(input)
+}
+
+// compilerOptions returns the options for compiling input. The files it
+// includes are recorded in opts.included.
+func (opts *options) compilerOptions(input string) compiler.Options {
+	opts.included = nil
+	return compiler.Options{
+		Filename:     input,
+		IncludePaths: opts.includePaths,
+		Included: func(path string) {
+			opts.included = append(opts.included, path)
+		},
+		Macros:           opts.macros,
+		MaxErrors:        opts.maxErrors,
+		Warnings:         opts.warnings,
+		WarningsAsErrors: opts.werror,
+		Report:           opts.report,
+	}
+}
+
+// writeDependencies writes a Make rule for target, which depends on
+// input and the files it included.
+func (opts *options) writeDependencies(w io.Writer, target string, input string) error {
+	seen := map[string]bool{}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", target, input)
+	for _, path := range opts.included {
+		if !seen[path] {
+			seen[path] = true
+			fmt.Fprintf(&b, " \\\n  %s", path)
+		}
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// saveDependencies writes the dependencies of input for -MD, to the
+// -MF file or else to a .d file named after the object file.
+func (opts *options) saveDependencies(input string) error {
+	target := replaceExt(input, ".o")
+	if opts.objOnly && opts.output != "" {
+		target = opts.output
+	}
+	path := opts.depOutput
+	if path == "" {
+		path = strings.TrimSuffix(target, filepath.Ext(target)) + ".d"
+	}
+	f, err := os.Create(path)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return err
+	}
+	if err := opts.writeDependencies(f, target, input); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// preprocess handles -E and -M: it writes the preprocessed inputs or
+// their dependencies to the output file or the standard output.
+func preprocess(opts *options) error {
+	w := io.Writer(os.Stdout)
+	path := opts.output
+	if opts.depOnly && opts.depOutput != "" {
+		path = opts.depOutput
+	}
+	if path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	for _, input := range opts.inputs {
+		bytes, err := readInput(input)
+		if err != nil {
+			return err
+		}
+		out := w
+		if opts.depOnly {
+			out = io.Discard
+		}
+		if err := compiler.Preprocess(out, bytes, opts.compilerOptions(input)); err != nil {
+			return err
+		}
+		if opts.depOnly {
+			err = opts.writeDependencies(w, replaceExt(input, ".o"), input)
+		} else if opts.writeDeps {
+			err = opts.saveDependencies(input)
+		}
+		if err != nil {
+			return err
+		}
 	}
-	input := string(bytes)
-	compile(input)
+	return nil
 }
 
-func compile(input string) {
-	token := Tokenize(input)
-	parser := NewParser(token)
-	prog := parser.Program()
-	for i := range prog.funcs {
-		prog.funcs[i].AddType()
+// compileFile compiles the C source in input to assembly in output.
+// An output of "-" writes to the standard output.
+func compileFile(input string, output string, opts *options) error {
+	bytes, err := readInput(input)
+	if err != nil {
+		return err
 	}
 
-	for i := range prog.funcs {
-		offset := 0
-		for j := range prog.funcs[i].locals {
-			offset += prog.funcs[i].locals[j].ty.size()
-			prog.funcs[i].locals[j].offset = offset
+	asm, err := compiler.Compile(bytes, opts.compilerOptions(input))
+	if err != nil {
+		return err
+	}
+	if opts.writeDeps {
+		if err := opts.saveDependencies(input); err != nil {
+			return err
 		}
-		prog.funcs[i].stackSize = offset
 	}
 
-	prog.Codegen()
+	if output == "-" {
+		_, err = os.Stdout.Write(asm)
+		return err
+	}
+	return os.WriteFile(output, asm, 0644)
+}
+
+func run(opts *options) error {
+	if opts.preprocessOnly || opts.depOnly {
+		return preprocess(opts)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "gocc-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	ldInputs := []string{}
+	for i, input := range opts.inputs {
+		tmpFile := func(ext string) string {
+			return filepath.Join(tmpDir, fmt.Sprintf("%d-%s", i, replaceExt(input, ext)))
+		}
+
+		switch filepath.Ext(input) {
+		case ".o", ".a", ".so":
+			ldInputs = append(ldInputs, input)
+			continue
+		case ".s":
+			if opts.asmOnly {
+				continue
+			}
+			output := opts.output
+			if !opts.objOnly {
+				output = tmpFile(".o")
+				ldInputs = append(ldInputs, output)
+			} else if output == "" {
+				output = replaceExt(input, ".o")
+			}
+			if err := assemble(input, output); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if opts.asmOnly {
+			output := opts.output
+			if output == "" {
+				output = replaceExt(input, ".s")
+			}
+			if err := compileFile(input, output, opts); err != nil {
+				return err
+			}
+			continue
+		}
+
+		asm := tmpFile(".s")
+		if err := compileFile(input, asm, opts); err != nil {
+			return err
+		}
+
+		output := opts.output
+		if !opts.objOnly {
+			output = tmpFile(".o")
+			ldInputs = append(ldInputs, output)
+		} else if output == "" {
+			output = replaceExt(input, ".o")
+		}
+		if err := assemble(asm, output); err != nil {
+			return err
+		}
+	}
+
+	if opts.asmOnly || opts.objOnly {
+		return nil
+	}
+
+	output := opts.output
+	if output == "" {
+		output = "a.out"
+	}
+	return link(ldInputs, output, opts.linkArgs)
+}
+
+func main() {
+	opts, err := parseArgs(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+	err = run(opts)
+	if err := opts.printDiagnostics(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		os.Exit(1)
+	}
+	if err != nil {
+		var errs compiler.ErrorList
+		if errors.As(err, &errs) {
+			// The errors have already been reported.
+			if len(errs) == opts.maxErrors && opts.diagFormat == "text" {
+				fmt.Fprintf(os.Stderr, "compilation terminated due to -fmax-errors=%d.\n", opts.maxErrors)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[0], err)
+		}
+		os.Exit(1)
+	}
 }
@@ -3,96 +3,158 @@ package main
 import (
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"testing"
-)
-
-func TestCompile(t *testing.T) {
-	type testData struct {
-		expected int
-		input    string
-	}
-	data := []testData{
-		{0, "int main() { return 0; }"},
-		{42, "int main() {return 42; }"},
-		{21, "int main() { return 5+20-4; }"},
-		{41, "int main() { return  12 + 34 - 5 ; }"},
-		{47, "int main() { return 5+6*7; }"},
-		{0, "int main() { return 0==1; }"},
-		{1, "int main() { return 0<1; }"},
-		{1, "int main() { return 1>0; }"},
-		{3, "int main() { int foo=3; return foo; }"},
-		{8, "int main() { int foo123=3; int bar=5; return foo123+bar; }"},
-
-		{3, "int main() { if (0) return 2; return 3; }"},
-		{3, "int main() { if (1-1) return 2; return 3; }"},
-		{2, "int main() { if (1) return2; return3; }"},
-		{2, "int main() { if (2-1) return2; return3; }"},
-
-		{10, "int main () { int i=0; while(i<10) i=i+1; return i; }"},
-
-		{55, "int main() { int i=0; int j=0; for (i=0; i<=10; i=i+1) j=i+j; return j; }"},
-		{3, "int main() { for (;;) return 3; return 5; }"},
-
-		{32, "int main() { return ret32(); } int ret32() { return 32; }"},
-		{7, "int main() { return add2(3,4); } int add2(int x, int y) { return x+y; }"},
-		{55, "int main() { return fib(9); } int fib(int x) { if (x<=1) return 1; return fib(x-1) + fib(x-2); }"},
 
-		{8, "int main() { int x=3; int y=5; return foo(&x, y); } int foo(int *x, int y) { return *x + y; }"},
-
-		{3, "int main() { int x[2]; int *y=&x; *y=3; return *x; }"},
-		{1, "int main() { int x[2][3]; int *y=x; *(y+1)=1; return *(*x+1); }"},
-
-		{1, "int main() { int x[2][3]; int *y=x; y[1]=1; return x[0][1]; }"},
-
-		{8, "int main() { int x; return sizeof(x); }"},
-		{8, "int main() { int x; return sizeof x; }"},
-		{8, "int main() { int *x; return sizeof(x); }"},
-		{32, "int main() { int x[4]; return sizeof(x); }"},
+	"gocc/compiler"
+)
 
-		{3, "int x; int main() { x=3; return x; }"},
-		{8, "int x; int main() { return sizeof(x); }"},
+func TestDriver(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "prog.c")
+	if err := os.WriteFile(src, []byte("int main() { return add2(3,4); } int add2(int x, int y) { return x+y; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
 
-		{1, "int main() { char x=1; return x; }"},
-		{1, "int main() { char x; return sizeof(x); }"},
-		{10, "int main() { char x[10]; return sizeof(x); }"},
+	exe := filepath.Join(dir, "prog")
+	if err := run(&options{inputs: []string{src}, output: exe}); err != nil {
+		t.Fatalf("failed to build executable: %v", err)
+	}
+	cmd := exec.Command(exe)
+	cmd.Run()
+	if exitCode := cmd.ProcessState.ExitCode(); exitCode != 7 {
+		t.Errorf("%v => %v (expected: 7)", src, exitCode)
+	}
 
-		{98, "int main() { return \"abc\"[1]; }"},
-		{9, "int main() { return \"\\t\"[0]; }"},
-		{2, "int main() { int x=2; { int x=3; } return x; }"},
+	asm := filepath.Join(dir, "prog.s")
+	if err := run(&options{inputs: []string{src}, output: asm, asmOnly: true}); err != nil {
+		t.Fatalf("failed to generate assembly: %v", err)
+	}
+	obj := filepath.Join(dir, "prog.o")
+	if err := run(&options{inputs: []string{asm}, output: obj, objOnly: true}); err != nil {
+		t.Fatalf("failed to assemble: %v", err)
+	}
+	exe2 := filepath.Join(dir, "prog2")
+	if err := run(&options{inputs: []string{obj}, output: exe2}); err != nil {
+		t.Fatalf("failed to link: %v", err)
+	}
+	cmd = exec.Command(exe2)
+	cmd.Run()
+	if exitCode := cmd.ProcessState.ExitCode(); exitCode != 7 {
+		t.Errorf("%v => %v (expected: 7)", obj, exitCode)
+	}
+}
 
-		{1, "int main() { struct {int a; int b;} x; x.a=1; x.b=2; return x.a; }"},
+func TestMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.c": "static int hidden; int counter; int bump() { hidden = 5; counter = counter + 1; return counter; }\n",
+		"b.c": "static int hidden; extern int counter; int bump();\nint main() { bump(); bump(); return counter * 10 + hidden; }\n",
+	}
+	var inputs []string
+	for _, name := range []string{"a.c", "b.c"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, path)
 	}
 
-	exeFile := "tmp"
+	exe := filepath.Join(dir, "prog")
+	if err := run(&options{inputs: inputs, output: exe}); err != nil {
+		t.Fatalf("failed to build executable: %v", err)
+	}
+	cmd := exec.Command(exe)
+	cmd.Run()
+	if exitCode := cmd.ProcessState.ExitCode(); exitCode != 20 {
+		t.Errorf("%v => %v (expected: 20)", inputs, exitCode)
+	}
+}
 
-	for _, v := range data {
-		r, w, _ := os.Pipe()
+func TestDependencies(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"prog.c": "#include \"a.h\"\nint main() { return A; }\n",
+		"a.h":    "#include \"b.h\"\n#define A B\n",
+		"b.h":    "#define B 1\n",
+	}
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src := filepath.Join(dir, "prog.c")
+	expected := func(target string) string {
+		return target + ": " + src + " \\\n  " + filepath.Join(dir, "a.h") + " \\\n  " + filepath.Join(dir, "b.h") + "\n"
+	}
 
-		backup := os.Stdout
-		os.Stdout = w
+	deps := filepath.Join(dir, "deps")
+	if err := run(&options{inputs: []string{src}, depOnly: true, depOutput: deps}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(deps); string(b) != expected("prog.o") {
+		t.Errorf("-M wrote %q (expected: %q)", b, expected("prog.o"))
+	}
 
-		args := []string{"-x", "assembler", "-", "-static", "-o", exeFile}
-		cmd := exec.Command("gcc", args...)
+	obj := filepath.Join(dir, "prog.o")
+	if err := run(&options{inputs: []string{src}, output: obj, objOnly: true, writeDeps: true}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, "prog.d")); string(b) != expected(obj) {
+		t.Errorf("-MD wrote %q (expected: %q)", b, expected(obj))
+	}
+}
 
-		compile(v.input)
-		w.Close()
+func TestParseArgs(t *testing.T) {
+	opts, err := parseArgs([]string{"-Wall", "-Wno-unused-variable", "-Wshadow", "-Werror", "-fmax-errors=3", "-c", "a.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]bool{
+		compiler.WarnUnusedVariable:  false,
+		compiler.WarnReturnType:      true,
+		compiler.WarnUnreachableCode: true,
+		compiler.WarnShadow:          true,
+	}
+	if !reflect.DeepEqual(opts.warnings, expected) {
+		t.Errorf("warnings = %v (expected: %v)", opts.warnings, expected)
+	}
+	if !opts.werror || opts.maxErrors != 3 || !opts.objOnly {
+		t.Errorf("unexpected options: %+v", opts)
+	}
 
-		cmd.Stdin = r
+	opts, err = parseArgs([]string{"-I", "inc", "-Iinclude", "a.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(opts.includePaths, []string{"inc", "include"}) {
+		t.Errorf("includePaths = %v", opts.includePaths)
+	}
 
-		if err := cmd.Run(); err != nil {
-			t.Fatalf("Failed to build program: %v", err)
-		}
-		r.Close()
-		os.Stdout = backup
+	opts, err = parseArgs([]string{"-DA", "-D", "B=2", "-UA", "-DF(x)=x+1", "a.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	macros := []compiler.MacroDef{
+		{Name: "A", Value: "1"},
+		{Name: "B", Value: "2"},
+		{Name: "A", Undef: true},
+		{Name: "F(x)", Value: "x+1"},
+	}
+	if !reflect.DeepEqual(opts.macros, macros) {
+		t.Errorf("macros = %v (expected: %v)", opts.macros, macros)
+	}
 
-		cmd = exec.Command("./tmp")
-		cmd.Run()
-		exitCode := cmd.ProcessState.ExitCode()
-		t.Logf("%v => %v (expected: %v)\n", v.input, exitCode, v.expected)
-		if exitCode != v.expected {
-			t.Errorf("Failed to run program")
+	for _, args := range [][]string{
+		{"-Wfoo", "a.c"},
+		{"a.c", "-I"},
+		{"-fmax-errors=x", "a.c"},
+		{"-o", "a", "-c", "a.c", "b.c"},
+		{},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("%v: expected an error", args)
 		}
-
-		os.Remove(exeFile)
 	}
 }